@@ -0,0 +1,40 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone/drone-gc/gc/audit"
+	"github.com/rs/zerolog/log"
+)
+
+// policies recorded in the audit log to explain why a
+// resource was selected for removal.
+const (
+	policyExpired   = "expired"
+	policyMalformed = "malformed"
+	policyDangling  = "dangling"
+	policyThreshold = "threshold"
+)
+
+// audit writes the outcome of an action to the audit log.
+func (c *collector) audit(ctx context.Context, r audit.Record, err error) {
+	r.Time = time.Now().UTC()
+	r.Host = c.host
+	r.Profile = c.profileName
+	r.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		r.Outcome = audit.OutcomeFailure
+		r.Error = err.Error()
+	}
+	if err := c.auditor.Log(r); err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Str("id", r.ID).
+			Msg("cannot write audit record")
+	}
+}
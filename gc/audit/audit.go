@@ -0,0 +1,43 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package audit
+
+import "time"
+
+// Outcome values recorded for each action.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Record describes a single destructive action taken by the
+// garbage collector against a Docker resource.
+type Record struct {
+	Time    time.Time         `json:"time"`
+	Host    string            `json:"host,omitempty"`
+	Action  string            `json:"action"`
+	Kind    string            `json:"kind"`
+	ID      string            `json:"id"`
+	Names   []string          `json:"names,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Size    int64             `json:"size,omitempty"`
+	Policy  string            `json:"policy"`
+	Detail  string            `json:"detail,omitempty"`
+	Profile string            `json:"profile,omitempty"`
+	Outcome string            `json:"outcome"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// Logger writes audit records.
+type Logger interface {
+	Log(Record) error
+}
+
+// Discard is a Logger that discards all records.
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Log(Record) error { return nil }
@@ -0,0 +1,95 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	f, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Log(Record{Action: "remove", Kind: "image", ID: "a180b24e38ed", Outcome: OutcomeSuccess})
+	f.Log(Record{Action: "remove", Kind: "volume", ID: "4e38e38c8ce0", Outcome: OutcomeFailure, Error: "in use"})
+	f.Close()
+
+	got := readRecords(t, path)
+	if len(got) != 2 {
+		t.Fatalf("Want 2 audit records, got %d", len(got))
+	}
+	if got[0].ID != "a180b24e38ed" || got[1].Error != "in use" {
+		t.Errorf("Unexpected audit records %v", got)
+	}
+}
+
+func TestFile_Rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	f, err := Open(path, 150, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// each record is roughly 120 bytes, which means every
+	// record is written to a new file.
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if err := f.Log(Record{Action: "remove", Kind: "image", ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		path:        "d",
+		path + ".1": "c",
+		path + ".2": "b",
+	} {
+		got := readRecords(t, name)
+		if len(got) != 1 || got[0].ID != want {
+			t.Errorf("Want file %s to contain record %q, got %v", name, want, got)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Want at most 2 rotated files")
+	}
+}
+
+func readRecords(t *testing.T, path string) []Record {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"time"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/events"
+)
+
+// Backend defines the container runtime operations required
+// by the garbage collector. Resources are described using the
+// Docker Engine API types, which are also implemented by
+// compatible runtimes.
+type Backend interface {
+	// ContainerList returns all containers, including
+	// stopped containers.
+	ContainerList(ctx context.Context) ([]types.Container, error)
+
+	// ContainerKill kills the running container.
+	ContainerKill(ctx context.Context, id string) error
+
+	// ContainerRemove forcibly removes the container and its
+	// anonymous volumes.
+	ContainerRemove(ctx context.Context, id string) error
+
+	// ImageInspect returns the image details.
+	ImageInspect(ctx context.Context, image string) (types.ImageInspect, error)
+
+	// ImageRemove removes the image by id or name.
+	ImageRemove(ctx context.Context, image string, opts types.ImageRemoveOptions) error
+
+	// ImagesPrune removes dangling images created before
+	// the given duration.
+	ImagesPrune(ctx context.Context, until time.Duration) (types.ImagesPruneReport, error)
+
+	// NetworkList returns all networks.
+	NetworkList(ctx context.Context) ([]types.NetworkResource, error)
+
+	// NetworkRemove removes the network.
+	NetworkRemove(ctx context.Context, id string) error
+
+	// VolumeList returns all volumes.
+	VolumeList(ctx context.Context) ([]*types.Volume, error)
+
+	// VolumeInspect returns the volume details.
+	VolumeInspect(ctx context.Context, name string) (*types.Volume, error)
+
+	// VolumeRemove removes the volume.
+	VolumeRemove(ctx context.Context, name string) error
+
+	// DiskUsage returns the disk usage report.
+	DiskUsage(ctx context.Context) (types.DiskUsage, error)
+
+	// Events streams runtime events until the context is
+	// cancelled or the stream fails.
+	Events(ctx context.Context, opts EventOptions) (<-chan events.Message, <-chan error)
+}
+
+// EventOptions filters the runtime event stream.
+type EventOptions struct {
+	// Types limits the stream to the event types, for
+	// example container or image. Empty means all types.
+	Types []string
+
+	// Actions limits the stream to the event actions, for
+	// example create or pull. Empty means all actions.
+	Actions []string
+
+	// Since replays the events emitted at or after the
+	// given time, if not zero. The runtime may only retain
+	// a limited number of past events.
+	Since time.Time
+}
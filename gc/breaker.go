@@ -0,0 +1,134 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"docker.io/go-docker/api/types"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrBreakerOpen is returned when a collection cycle is
+// aborted by the mass-deletion circuit breaker.
+var ErrBreakerOpen = errors.New("image removal aborted by circuit breaker")
+
+// Breaker is a mass-deletion circuit breaker. It aborts image
+// eviction when a cycle would remove more than the configured
+// percentage of the local images, until an operator overrides
+// the breaker.
+type Breaker struct {
+	mu sync.Mutex
+
+	percent  float64
+	tripped  bool
+	override bool
+	plan     map[string]bool // image ids of the tripped plan
+}
+
+// NewBreaker returns a circuit breaker that trips when a
+// cycle would remove more than percent of the local images.
+func NewBreaker(percent float64) *Breaker {
+	return &Breaker{percent: percent}
+}
+
+// WithCircuitBreaker returns an option to set the mass-deletion
+// circuit breaker.
+func WithCircuitBreaker(breaker *Breaker) Option {
+	return func(c *collector) {
+		c.breaker = breaker
+	}
+}
+
+// Override allows the next cycle to proceed even if it would
+// trip the breaker, and closes the breaker. If the breaker is
+// tripped, the override only allows the plan that tripped the
+// breaker, or a subset of it. The override is cleared by the
+// next cycle.
+func (b *Breaker) Override() {
+	b.mu.Lock()
+	b.override = true
+	b.mu.Unlock()
+}
+
+// Tripped returns true if the breaker aborted the last cycle
+// and is waiting for an operator override.
+func (b *Breaker) Tripped() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tripped
+}
+
+// allow returns true if the planned removals may proceed.
+func (b *Breaker) allow(ctx context.Context, plan []types.ImageInspect, total int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the override only applies to a single cycle.
+	override := b.override
+	b.override = false
+
+	logger := log.Ctx(ctx)
+	if total == 0 || float64(len(plan))*100/float64(total) <= b.percent {
+		b.tripped = false
+		b.plan = nil
+		return true
+	}
+	if override && b.approved(plan) {
+		logger.Warn().
+			Int("planned", len(plan)).
+			Int("total", total).
+			Msg("circuit breaker overridden, proceeding with image removal")
+		b.tripped = false
+		b.plan = nil
+		return true
+	}
+
+	b.tripped = true
+	b.plan = map[string]bool{}
+	for _, info := range plan {
+		b.plan[info.ID] = true
+	}
+	var names []string
+	for _, info := range plan {
+		names = append(names, imageNames(info)...)
+	}
+	logger.Error().
+		Int("planned", len(plan)).
+		Int("total", total).
+		Float64("percent", b.percent).
+		Strs("plan", names).
+		Msg("circuit breaker tripped, waiting for operator override")
+	return false
+}
+
+// approved returns true if the plan is a subset of the plan
+// that tripped the breaker, or if the breaker is not tripped.
+func (b *Breaker) approved(plan []types.ImageInspect) bool {
+	if !b.tripped {
+		return true
+	}
+	for _, info := range plan {
+		if !b.plan[info.ID] {
+			return false
+		}
+	}
+	return true
+}
+
+// imageNames returns the tags of the image, or the digests
+// or ID if the image is untagged.
+func imageNames(info types.ImageInspect) []string {
+	switch {
+	case len(info.RepoTags) != 0:
+		return info.RepoTags
+	case len(info.RepoDigests) != 0:
+		return info.RepoDigests
+	default:
+		return []string{info.ID}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone/drone-gc/mocks"
+
+	"docker.io/go-docker/api/types"
+	"github.com/golang/mock/gomock"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// this test verifies that the circuit breaker aborts image
+// removal when the cycle would remove too many images, and
+// that the cycle proceeds once the breaker is overridden.
+func TestCollectImages_Breaker(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockdf := types.DiskUsage{
+		LayersSize: 900,
+		Images: []*types.ImageSummary{
+			{ID: "a180b24e38ed", Created: 359596800, Size: 300},
+			{ID: "4e38e38c8ce0", Created: 359596800, Size: 300},
+			{ID: "481995377a04", Created: 359596800, Size: 300},
+		},
+	}
+	mockImages := []types.ImageInspect{
+		{ID: "a180b24e38ed", RepoTags: []string{"alpine:latest"}},
+		{ID: "4e38e38c8ce0", RepoTags: []string{"busybox:latest"}},
+		{ID: "481995377a04", RepoTags: []string{"golang:latest"}},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil).Times(2)
+	for _, image := range mockImages {
+		client.EXPECT().ImageInspectWithRaw(gomock.Any(), image.ID).Return(image, nil, nil).Times(2)
+	}
+
+	breaker := NewBreaker(50)
+	c := New(NewDockerBackend(client), WithThreshold(100), WithCircuitBreaker(breaker)).(*collector)
+
+	err := c.collectImages(context.Background())
+	if merr, ok := err.(*multierror.Error); !ok || merr.Errors[0] != ErrBreakerOpen {
+		t.Errorf("Want circuit breaker error, got %v", err)
+	}
+	if !breaker.Tripped() {
+		t.Errorf("Want circuit breaker tripped")
+	}
+
+	for _, image := range mockImages {
+		client.EXPECT().ImageRemove(gomock.Any(), image.RepoTags[0], types.ImageRemoveOptions{}).Return(nil, nil)
+	}
+	breaker.Override()
+	if err := c.collectImages(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if breaker.Tripped() {
+		t.Errorf("Want circuit breaker closed after override")
+	}
+}
+
+// this test verifies that a failed removal does not cause the
+// removal of images outside the plan approved by the breaker.
+func TestCollectImages_BreakerPlan(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockdf := types.DiskUsage{
+		LayersSize: 900,
+		Images: []*types.ImageSummary{
+			{ID: "a180b24e38ed", Created: 359596800, Size: 300},
+			{ID: "4e38e38c8ce0", Created: 359596800, Size: 300},
+			{ID: "481995377a04", Created: 359596800, Size: 300},
+		},
+	}
+	mockImages := []types.ImageInspect{
+		{ID: "a180b24e38ed", RepoTags: []string{"alpine:latest"}},
+		{ID: "4e38e38c8ce0", RepoTags: []string{"busybox:latest"}},
+		{ID: "481995377a04", RepoTags: []string{"golang:latest"}},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
+	for _, image := range mockImages {
+		client.EXPECT().ImageInspectWithRaw(gomock.Any(), image.ID).Return(image, nil, nil)
+	}
+	client.EXPECT().ImageRemove(gomock.Any(), "alpine:latest", types.ImageRemoveOptions{}).Return(nil, errors.New("conflict"))
+	client.EXPECT().ImageRemove(gomock.Any(), "busybox:latest", types.ImageRemoveOptions{}).Return(nil, nil)
+	// we DO NOT remove the image outside the approved plan
+
+	breaker := NewBreaker(100)
+	c := New(NewDockerBackend(client), WithThreshold(500), WithCircuitBreaker(breaker)).(*collector)
+	if err := c.collectImages(context.Background()); err == nil {
+		t.Errorf("Want removal error")
+	}
+}
+
+func TestBreaker_Override(t *testing.T) {
+	small := []types.ImageInspect{{ID: "a180b24e38ed"}}
+	large := []types.ImageInspect{{ID: "a180b24e38ed"}, {ID: "4e38e38c8ce0"}, {ID: "481995377a04"}}
+	ctx := context.Background()
+
+	// the override is cleared by a cycle below the limit.
+	breaker := NewBreaker(50)
+	breaker.Override()
+	if !breaker.allow(ctx, small, 4) {
+		t.Errorf("Want small cycle allowed")
+	}
+	if breaker.allow(ctx, large, 4) {
+		t.Errorf("Want large cycle to trip the breaker after the override was cleared")
+	}
+
+	// the override only allows the plan that tripped the
+	// breaker, or a subset of it.
+	breaker.Override()
+	if breaker.allow(ctx, append(large, types.ImageInspect{ID: "6d8c4adbca87"}), 4) {
+		t.Errorf("Want a different plan to trip the breaker")
+	}
+	breaker.Override()
+	if !breaker.allow(ctx, large[1:], 3) {
+		t.Errorf("Want a subset of the tripped plan allowed")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import "sync"
+
+// Budget limits the resources removed in a single collection
+// cycle. A zero value means unlimited.
+type Budget struct {
+	Images     int
+	Bytes      int64
+	Containers int
+}
+
+// WithBudget returns an option to limit the number of images,
+// image bytes and containers removed per collection cycle.
+func WithBudget(budget Budget) Option {
+	return func(c *collector) {
+		c.budget = budget
+	}
+}
+
+// spending tracks the resources removed in the current
+// collection cycle.
+type spending struct {
+	sync.Mutex
+	images     int
+	bytes      int64
+	containers int
+}
+
+// reserveImage reserves budget for an image removal. It
+// returns false if the budget is exhausted.
+func (c *collector) reserveImage(size int64) bool {
+	s := c.spent
+	s.Lock()
+	defer s.Unlock()
+	if c.budget.Images > 0 && s.images+1 > c.budget.Images {
+		return false
+	}
+	if c.budget.Bytes > 0 && s.bytes+size > c.budget.Bytes {
+		return false
+	}
+	s.images++
+	s.bytes += size
+	return true
+}
+
+// releaseImage returns budget reserved for an image removal
+// that failed.
+func (c *collector) releaseImage(size int64) {
+	s := c.spent
+	s.Lock()
+	s.images--
+	s.bytes -= size
+	s.Unlock()
+}
+
+// reserveContainer reserves budget for a container removal.
+// It returns false if the budget is exhausted.
+func (c *collector) reserveContainer() bool {
+	s := c.spent
+	s.Lock()
+	defer s.Unlock()
+	if c.budget.Containers > 0 && s.containers+1 > c.budget.Containers {
+		return false
+	}
+	s.containers++
+	return true
+}
+
+// releaseContainer returns budget reserved for a container
+// removal that failed.
+func (c *collector) releaseContainer() {
+	s := c.spent
+	s.Lock()
+	s.containers--
+	s.Unlock()
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone/drone-gc/mocks"
+
+	"docker.io/go-docker/api/types"
+	"github.com/golang/mock/gomock"
+)
+
+// this test verifies that no more images are removed once
+// the image budget for the cycle is exhausted, even if the
+// cache is still above the threshold.
+func TestCollectImages_Budget(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockdf := types.DiskUsage{
+		LayersSize: 900,
+		Images: []*types.ImageSummary{
+			{ID: "a180b24e38ed", Created: 359596800, Size: 300},
+			{ID: "4e38e38c8ce0", Created: 359596800, Size: 300},
+			{ID: "481995377a04", Created: 359596800, Size: 300},
+		},
+	}
+	mockImages := []types.ImageInspect{
+		{ID: "a180b24e38ed"},
+		{ID: "4e38e38c8ce0"},
+		{ID: "481995377a04"},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
+	client.EXPECT().ImageInspectWithRaw(gomock.Any(), mockImages[0].ID).Return(mockImages[0], nil, nil)
+	client.EXPECT().ImageInspectWithRaw(gomock.Any(), mockImages[1].ID).Return(mockImages[1], nil, nil)
+	client.EXPECT().ImageRemove(gomock.Any(), mockImages[0].ID, types.ImageRemoveOptions{}).Return(nil, nil)
+	// we DO NOT remove image 4e38e38c8ce0 because it exceeds
+	// the byte budget.
+
+	c := New(NewDockerBackend(client), WithBudget(Budget{Images: 2, Bytes: 500})).(*collector)
+	err := c.collectImages(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectContainers_Budget(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockContainers := []types.Container{
+		{
+			ID:     "c3d2a6307f4e",
+			Names:  []string{"foo"},
+			State:  "exited",
+			Labels: map[string]string{"io.drone.expires": "915148800"},
+		},
+		{
+			ID:     "2b8fd9751c4c",
+			Names:  []string{"bar"},
+			State:  "exited",
+			Labels: map[string]string{"io.drone.expires": "915148800"},
+		},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil)
+	client.EXPECT().ContainerRemove(gomock.Any(), mockContainers[0].ID, containerRemoveOpts).Return(nil)
+
+	c := New(NewDockerBackend(client), WithBudget(Budget{Containers: 1})).(*collector)
+	err := c.collectContainers(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// this test verifies that budget reserved for a container
+// removal that fails is released.
+func TestCollectContainers_BudgetRelease(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockContainers := []types.Container{
+		{
+			ID:     "c3d2a6307f4e",
+			Names:  []string{"foo"},
+			State:  "exited",
+			Labels: map[string]string{"io.drone.expires": "915148800"},
+		},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil)
+	client.EXPECT().ContainerRemove(gomock.Any(), mockContainers[0].ID, containerRemoveOpts).Return(errors.New("container is in use"))
+
+	c := New(NewDockerBackend(client), WithBudget(Budget{Containers: 1})).(*collector)
+	c.collectContainers(context.Background())
+	if got := c.spent.containers; got != 0 {
+		t.Errorf("Want container budget released, got %d spent", got)
+	}
+}
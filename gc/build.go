@@ -0,0 +1,108 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-gc/gc/internal"
+
+	"docker.io/go-docker/api/types"
+	"github.com/rs/zerolog/log"
+)
+
+// deferral tracks how long image eviction has been postponed
+// because pipeline containers were running.
+type deferral struct {
+	sync.Mutex
+
+	labels []string // label key patterns
+	names  []string // container name patterns
+	max    time.Duration
+	since  time.Time
+}
+
+// WithBuildDetection returns an option to postpone image
+// eviction while pipeline containers are running. Running
+// containers are matched by label key pattern (for example
+// io.drone.stage.*) or by name pattern. Expired containers,
+// networks and volumes are still collected. Image eviction is
+// forced once it has been postponed for the maximum deferral.
+func WithBuildDetection(labels, names []string, max time.Duration) Option {
+	return func(c *collector) {
+		c.deferral = &deferral{
+			labels: labels,
+			names:  names,
+			max:    max,
+		}
+	}
+}
+
+// deferImageEviction returns true if image eviction should
+// be postponed because pipeline containers are running.
+func (c *collector) deferImageEviction(ctx context.Context) bool {
+	d := c.deferral
+	if d == nil {
+		return false
+	}
+	logger := log.Ctx(ctx)
+
+	running, err := c.client.ContainerList(ctx)
+	if err != nil {
+		logger.Warn().
+			Err(err).
+			Msg("cannot list running containers")
+		return false
+	}
+
+	var builds []string
+	for _, cc := range running {
+		if cc.State == "running" && d.match(cc, c.registries) {
+			builds = append(builds, cc.Names...)
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	if len(builds) == 0 {
+		d.since = time.Time{}
+		return false
+	}
+	now := c.now()
+	if d.since.IsZero() {
+		d.since = now
+	}
+	if d.max > 0 && now.Sub(d.since) >= d.max {
+		logger.Info().
+			Strs("builds", builds).
+			Dur("deferred", now.Sub(d.since)).
+			Msg("maximum deferral exceeded, forcing image eviction")
+		d.since = time.Time{}
+		return false
+	}
+	logger.Info().
+		Strs("builds", builds).
+		Dur("deferred", now.Sub(d.since)).
+		Msg("pipelines running, image eviction deferred")
+	return true
+}
+
+// match returns true if the container is a pipeline container.
+func (d *deferral) match(cc types.Container, registries internal.Registries) bool {
+	if matchPatterns(cc.Names, d.names, registries) {
+		return true
+	}
+	for key := range cc.Labels {
+		for _, pattern := range d.labels {
+			if matched, _ := path.Match(pattern, key); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
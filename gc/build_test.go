@@ -0,0 +1,86 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-gc/mocks"
+
+	"docker.io/go-docker/api/types"
+	"github.com/golang/mock/gomock"
+)
+
+func TestDeferImageEviction(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockContainers := []types.Container{
+		{
+			ID:     "c3d2a6307f4e",
+			Names:  []string{"/drone-agent"},
+			Labels: map[string]string{},
+			State:  "running",
+		},
+		{
+			ID:     "2b8fd9751c4c",
+			Names:  []string{"/drone_1a2b3c"},
+			Labels: map[string]string{"io.drone.stage.name": "default"},
+			State:  "running",
+		},
+		{
+			ID:     "9b1f2e5d3a7c",
+			Names:  []string{"/drone_4d5e6f"},
+			Labels: map[string]string{"io.drone.stage.name": "default"},
+			State:  "exited",
+		},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return([]types.Container{mockContainers[0], mockContainers[2]}, nil)
+
+	c := New(NewDockerBackend(client),
+		WithBuildDetection([]string{"io.drone.stage.*"}, nil, time.Hour),
+	).(*collector)
+	if !c.deferImageEviction(context.Background()) {
+		t.Errorf("Want image eviction deferred while pipelines are running")
+	}
+	if c.deferImageEviction(context.Background()) {
+		t.Errorf("Want image eviction resumed once pipelines complete")
+	}
+}
+
+func TestDeferImageEviction_MaxDeferral(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockContainers := []types.Container{
+		{
+			ID:    "2b8fd9751c4c",
+			Names: []string{"/drone_1a2b3c"},
+			State: "running",
+		},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil).Times(2)
+
+	c := New(NewDockerBackend(client),
+		WithBuildDetection(nil, []string{"/drone_*"}, time.Hour),
+	).(*collector)
+	if !c.deferImageEviction(context.Background()) {
+		t.Errorf("Want image eviction deferred while pipelines are running")
+	}
+
+	// simulate image eviction deferred for longer than
+	// the maximum deferral.
+	c.deferral.since = time.Now().Add(-2 * time.Hour)
+	if c.deferImageEviction(context.Background()) {
+		t.Errorf("Want image eviction forced after the maximum deferral")
+	}
+}
@@ -5,68 +5,271 @@
 package cache
 
 import (
+	"container/heap"
 	"sort"
 	"sync"
+
+	"github.com/drone/drone-gc/gc/internal"
 )
 
-// DefaultCacheSize is the default size of the LFRU cache.
+// DefaultCacheSize is the default number of images tracked
+// by the usage cache.
 const DefaultCacheSize = 1000
 
+// cache tracks the last use of images by image id. The tags
+// and digests of an image are aliases, which resolve to the
+// image id. When the limit is reached, the least recently
+// used image is dropped. The items are kept in a min-heap
+// ordered by last use, so that updates and evictions are
+// O(log n).
 type cache struct {
 	mu sync.Mutex
 
-	limit int
-	list  []*item
-	index map[string]*item
+	limit      int
+	list       usageHeap
+	index      map[string]*item // by image id
+	aliases    map[string]string
+	registries internal.Registries
 }
 
 type item struct {
-	Name string
-	Hits int
-	Last int64
+	ID         string
+	Names      []string
+	Hits       int
+	Last       int64
+	Attributes map[string]string
+
+	pos int // position in the heap
 }
 
 func newCache(limit int) *cache {
 	return &cache{
-		limit: limit,
-		list:  []*item{},
-		index: make(map[string]*item),
+		limit:   limit,
+		list:    usageHeap{},
+		index:   make(map[string]*item),
+		aliases: make(map[string]string),
 	}
 }
 
-func (c *cache) push(name string, value int64) {
+// push records the use of the image, along with the
+// attributes of the event actor.
+func (c *cache) push(id string, value int64, attrs map[string]string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	i, ok := c.index[name]
+	c.put(id, value, attrs)
+}
+
+// put records the use of the image. The lock must be held.
+func (c *cache) put(id string, value int64, attrs map[string]string) {
+	i, ok := c.index[id]
 	if ok {
 		i.Last = value
 		i.Hits++
-	} else {
-		i = &item{
-			Name: name,
-			Hits: 1,
-			Last: value,
-		}
-		c.list = append(c.list, i)
-		c.index[name] = i
+		i.Attributes = attrs
+		heap.Fix(&c.list, i.pos)
+		return
 	}
-	sort.Sort(byLastUsed(c.list))
-	if len(c.list) > c.limit {
-		c.list = c.list[:c.limit]
+	i = &item{
+		ID:         id,
+		Hits:       1,
+		Last:       value,
+		Attributes: attrs,
+	}
+	heap.Push(&c.list, i)
+	c.index[id] = i
+	for c.limit > 0 && len(c.list) > c.limit {
+		c.drop(heap.Pop(&c.list).(*item))
+	}
+}
+
+// update records the use of the image at the given time,
+// unless a later use is already recorded.
+func (c *cache) update(id string, value int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, ok := c.index[id]
+	if !ok {
+		c.put(id, value, nil)
+	} else if i.Last < value {
+		c.put(id, value, i.Attributes)
 	}
 }
 
-func (c *cache) find(name string) (v int64, ok bool) {
+// find returns the last use of the image.
+func (c *cache) find(id string) (v int64, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if item, ok := c.index[name]; ok {
+	if item, ok := c.index[id]; ok {
 		return item.Last, true
 	}
 	return
 }
 
-type byLastUsed []*item
+// hits returns the number of recorded uses of the image.
+func (c *cache) hits(id string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item, ok := c.index[id]; ok {
+		return item.Hits
+	}
+	return 0
+}
 
-func (a byLastUsed) Len() int           { return len(a) }
-func (a byLastUsed) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byLastUsed) Less(i, j int) bool { return a[i].Last > a[j].Last }
+// alias records the image name, a tag or digest, as an alias
+// of the image id. A name moved from another image is removed
+// from that image, which keeps its own use history. Names of
+// images not in the cache are ignored.
+func (c *cache) alias(name, id string) {
+	name = c.expand(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, ok := c.index[id]
+	if !ok {
+		return
+	}
+	prev, ok := c.aliases[name]
+	if ok && prev == id {
+		return
+	}
+	if ok {
+		c.unalias(name)
+	}
+	c.aliases[name] = id
+	i.Names = append(i.Names, name)
+}
+
+// resolve returns the image id of the name.
+func (c *cache) resolve(name string) (string, bool) {
+	name = c.expand(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.aliases[name]
+	return id, ok
+}
+
+// len returns the number of images in the cache.
+func (c *cache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.list)
+}
+
+// items returns a copy of the cached items, most recently
+// used first.
+func (c *cache) items() []item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]item, 0, len(c.list))
+	for _, i := range c.list {
+		v := *i
+		v.Names = append([]string(nil), i.Names...)
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return c.list.less(&out[j], &out[i])
+	})
+	return out
+}
+
+// remove removes the image name, returning true if the name
+// was an alias. The image use history is kept until the image
+// is deleted.
+func (c *cache) remove(name string) bool {
+	name = c.expand(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.unalias(name)
+}
+
+// removeID removes the image and its aliases, returning true
+// if the image was in the cache.
+func (c *cache) removeID(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, ok := c.index[id]
+	if !ok {
+		return false
+	}
+	heap.Remove(&c.list, i.pos)
+	c.drop(i)
+	return true
+}
+
+// unalias removes the alias. The lock must be held.
+func (c *cache) unalias(name string) bool {
+	id, ok := c.aliases[name]
+	if !ok {
+		return false
+	}
+	delete(c.aliases, name)
+	if i, ok := c.index[id]; ok {
+		i.Names = without(i.Names, name)
+	}
+	return true
+}
+
+// drop removes the image removed from the heap from the
+// index. The lock must be held.
+func (c *cache) drop(i *item) {
+	delete(c.index, i.ID)
+	for _, name := range i.Names {
+		delete(c.aliases, name)
+	}
+}
+
+// expand returns the fully qualified image name or digest,
+// with registry aliases replaced by the canonical registry,
+// unless the reference is an image id.
+func (c *cache) expand(name string) string {
+	if isID(name) {
+		return name
+	}
+	return c.registries.Expand(name)
+}
+
+func without(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// usageHeap is a min-heap of items ordered by last use.
+type usageHeap []*item
+
+func (h usageHeap) Len() int           { return len(h) }
+func (h usageHeap) Less(i, j int) bool { return h.less(h[i], h[j]) }
+
+func (h usageHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *usageHeap) Push(x interface{}) {
+	i := x.(*item)
+	i.pos = len(*h)
+	*h = append(*h, i)
+}
+
+func (h *usageHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	i := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	i.pos = -1
+	return i
+}
+
+// less orders items by last use, and by id for items used at
+// the same time.
+func (h usageHeap) less(a, b *item) bool {
+	if a.Last != b.Last {
+		return a.Last < b.Last
+	}
+	return a.ID < b.ID
+}
@@ -5,56 +5,182 @@
 package cache
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/drone/drone-gc/gc/internal"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestCache(t *testing.T) {
 	c := newCache(5)
-	c.push("alpine:latest", 359596800)
-	c.push("busybox:latest", 420681600)
-	c.push("golang:1", 1192233600)
-	c.push("golang:1.9", 1192233603)
-	c.push("golang:1.8", 1192233602)
-	c.push("golang:1.7", 1192233601)
-	c.push("golang:1.7", 1192233601) // bump hit count x2
-	c.push("golang:1.7", 1192233601) // bump hit count x3
-
-	if got, want := len(c.list), 5; got != want {
+	c.push("sha256:8c811b4aec35", 359596800, nil)
+	c.push("sha256:59788edf1f3e", 420681600, nil)
+	c.push("sha256:a180b24e38ed", 1192233600, nil)
+	c.push("sha256:a7e7cd1d0e84", 1192233603, nil)
+	c.push("sha256:20e9c96ea3a0", 1192233602, nil)
+	c.push("sha256:f6c1f7b4b9a2", 1192233601, nil)
+	c.push("sha256:f6c1f7b4b9a2", 1192233601, nil) // bump hit count x2
+	c.push("sha256:f6c1f7b4b9a2", 1192233601, nil) // bump hit count x3
+
+	if got, want := c.len(), 5; got != want {
 		t.Errorf("Want %d items in the cache, got %d", want, got)
 	}
 
-	want := []*item{
-		&item{
+	want := []item{
+		{
 			Last: 1192233603,
 			Hits: 1,
-			Name: "golang:1.9",
+			ID:   "sha256:a7e7cd1d0e84",
 		},
-		&item{
+		{
 			Last: 1192233602,
 			Hits: 1,
-			Name: "golang:1.8",
+			ID:   "sha256:20e9c96ea3a0",
 		},
-		&item{
+		{
 			Last: 1192233601,
 			Hits: 3,
-			Name: "golang:1.7",
+			ID:   "sha256:f6c1f7b4b9a2",
 		},
-		&item{
+		{
 			Last: 1192233600,
 			Hits: 1,
-			Name: "golang:1",
+			ID:   "sha256:a180b24e38ed",
 		},
-		&item{
+		{
 			Last: 420681600,
 			Hits: 1,
-			Name: "busybox:latest",
+			ID:   "sha256:59788edf1f3e",
 		},
-		// note that we expect the alpine container is
+		// note that we expect the oldest image is
 		// removed because the cache limit is 5 items.
 	}
-	if !cmp.Equal(want, c.list) {
+	if diff := cmp.Diff(want, c.items(), cmpopts.IgnoreUnexported(item{})); diff != "" {
 		t.Errorf("Invalid cache order")
+		t.Log(diff)
+	}
+
+	// the evicted image must not be found.
+	if _, ok := c.find("sha256:8c811b4aec35"); ok {
+		t.Errorf("Want evicted image removed from the index")
+	}
+	if got, want := len(c.index), 5; got != want {
+		t.Errorf("Want %d items in the index, got %d", want, got)
+	}
+	if got, want := c.hits("sha256:f6c1f7b4b9a2"), 3; got != want {
+		t.Errorf("Want %d hits, got %d", want, got)
+	}
+}
+
+func TestCache_Alias(t *testing.T) {
+	c := newCache(1)
+	c.push("sha256:a180b24e38ed", 1192233600, nil)
+	c.alias("docker.io/library/golang:1", "sha256:a180b24e38ed")
+	c.alias("docker.io/library/golang@sha256:b1f2", "sha256:a180b24e38ed")
+	c.alias("docker.io/library/alpine:3", "sha256:8c811b4aec35") // not cached
+
+	if id, _ := c.resolve("docker.io/library/golang@sha256:b1f2"); id != "sha256:a180b24e38ed" {
+		t.Errorf("Want digest resolved to the image id, got %q", id)
+	}
+	if _, ok := c.resolve("docker.io/library/alpine:3"); ok {
+		t.Errorf("Want alias of uncached image ignored")
+	}
+
+	// the tag moves to a new image, which has its own
+	// use history.
+	c.limit = 2
+	c.push("sha256:20e9c96ea3a0", 1192233700, nil)
+	c.alias("docker.io/library/golang:1", "sha256:20e9c96ea3a0")
+	if id, _ := c.resolve("docker.io/library/golang:1"); id != "sha256:20e9c96ea3a0" {
+		t.Errorf("Want tag resolved to the new image id, got %q", id)
+	}
+	if got, _ := c.find("sha256:a180b24e38ed"); got != 1192233600 {
+		t.Errorf("Want previous image use history kept, got %d", got)
+	}
+	if got, want := c.index["sha256:a180b24e38ed"].Names, []string{"docker.io/library/golang@sha256:b1f2"}; !cmp.Equal(got, want) {
+		t.Errorf("Want moved tag removed from the previous image, got %v", got)
+	}
+
+	// evicting the image removes its aliases.
+	c.push("sha256:59788edf1f3e", 1192233800, nil)
+	if _, ok := c.resolve("docker.io/library/golang@sha256:b1f2"); ok {
+		t.Errorf("Want aliases of evicted image removed")
+	}
+}
+
+func TestCache_RegistryAliases(t *testing.T) {
+	c := newCache(5)
+	c.registries = internal.Registries{"mirror.internal": "docker.io"}
+	c.push("sha256:a180b24e38ed", 1192233600, nil)
+	c.alias("mirror.internal/library/golang:1", "sha256:a180b24e38ed")
+
+	for _, name := range []string{"golang:1", "docker.io/library/golang:1", "mirror.internal/golang:1"} {
+		if id, _ := c.resolve(name); id != "sha256:a180b24e38ed" {
+			t.Errorf("Want %s resolved to the image id, got %q", name, id)
+		}
+	}
+}
+
+func TestCache_Remove(t *testing.T) {
+	c := newCache(5)
+	c.push("sha256:8c811b4aec35", 359596800, nil)
+	c.push("sha256:a180b24e38ed", 1192233600, nil)
+	c.alias("docker.io/library/golang:1", "sha256:a180b24e38ed")
+	c.alias("docker.io/library/golang:latest", "sha256:a180b24e38ed")
+
+	if !c.remove("docker.io/library/golang:1") {
+		t.Errorf("Want alias removed")
+	}
+	if c.remove("docker.io/library/golang:1") {
+		t.Errorf("Want alias already removed")
+	}
+	if _, ok := c.find("sha256:a180b24e38ed"); !ok {
+		t.Errorf("Want image kept after removing an alias")
+	}
+	if !c.removeID("sha256:a180b24e38ed") {
+		t.Errorf("Want image removed")
+	}
+	if _, ok := c.resolve("docker.io/library/golang:latest"); ok {
+		t.Errorf("Want aliases of removed image removed")
+	}
+
+	want := []item{{ID: "sha256:8c811b4aec35", Hits: 1, Last: 359596800}}
+	if diff := cmp.Diff(want, c.items(), cmpopts.IgnoreUnexported(item{})); diff != "" {
+		t.Errorf("Invalid cache items")
+		t.Log(diff)
+	}
+	if got, want := len(c.index), 1; got != want {
+		t.Errorf("Want %d items in the index, got %d", want, got)
+	}
+}
+
+func BenchmarkCache_Burst(b *testing.B) {
+	names := make([]string, 5000)
+	for i := range names {
+		names[i] = fmt.Sprintf("sha256:%064x", i)
+	}
+	c := newCache(DefaultCacheSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.push(names[i%len(names)], int64(i), nil)
+	}
+}
+
+func BenchmarkCache_BurstRepeated(b *testing.B) {
+	names := make([]string, DefaultCacheSize)
+	for i := range names {
+		names[i] = fmt.Sprintf("sha256:%064x", i)
+	}
+	c := newCache(DefaultCacheSize)
+	for i, name := range names {
+		c.push(name, int64(i), nil)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.push(names[i%len(names)], int64(len(names)+i), nil)
 	}
 }
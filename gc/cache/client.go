@@ -7,41 +7,98 @@ package cache
 import (
 	"context"
 	"sort"
+	"strings"
+	"time"
 
-	"github.com/drone/drone-gc/gc/internal"
+	"github.com/drone/drone-gc/gc"
 
-	"docker.io/go-docker"
 	"docker.io/go-docker/api/types"
 )
 
 type client struct {
-	docker.APIClient
-	cache *cache
+	gc.Backend
+	cache   *cache
+	growth  *growth
+	regrets *regrets
+	now     func() time.Time
 }
 
 func (c *client) DiskUsage(ctx context.Context) (types.DiskUsage, error) {
-	df, err := c.APIClient.DiskUsage(ctx)
+	df, err := c.Backend.DiskUsage(ctx)
 	if err != nil {
 		return df, err
 	}
+	if c.growth != nil {
+		c.growth.reset(df)
+	}
+	if c.regrets != nil {
+		c.regrets.observe(df)
+	}
 	for _, image := range df.Images {
-		if len(image.RepoTags) == 0 {
-			continue
+		// the tags and digests are recorded as aliases, so
+		// that image references in events resolve to the
+		// image id.
+		for _, name := range imageNames(image) {
+			c.cache.alias(name, image.ID)
 		}
-	tags:
-		for _, tag := range image.RepoTags {
-			tag = internal.ExpandImage(tag)
-			unix, ok := c.cache.find(tag)
-			if ok {
-				image.Created = unix
-				break tags
-			}
+		if unix, ok := c.cache.find(image.ID); ok {
+			image.Created = unix
 		}
 	}
 	sort.Sort(byCreated(df.Images))
 	return df, err
 }
 
+// ImageRemove removes the image, and remembers the evicted
+// reference to track regret.
+func (c *client) ImageRemove(ctx context.Context, image string, opts types.ImageRemoveOptions) error {
+	// the reference is resolved before removal, since the
+	// alias is removed once the runtime reports the untag.
+	id, _ := c.cache.resolve(image)
+	err := c.Backend.ImageRemove(ctx, image, opts)
+	if err == nil && c.regrets != nil {
+		c.regrets.evict(image, id, c.now())
+	}
+	return err
+}
+
+// LastUsed returns the last use of the image recorded by the
+// cache, and false if the image was not used since the cache
+// was created.
+func (c *client) LastUsed(id string) (time.Time, bool) {
+	unix, ok := c.cache.find(id)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// Uses returns the number of uses of the image recorded by
+// the cache, and zero if the image was not used since the
+// cache was created.
+func (c *client) Uses(id string) int {
+	return c.cache.hits(id)
+}
+
+// Regret returns the eviction regret statistics.
+func (c *client) Regret() gc.RegretStats {
+	if c.regrets == nil {
+		return gc.RegretStats{}
+	}
+	return c.regrets.stats()
+}
+
+// imageNames returns the tags and digests of the image.
+func imageNames(image *types.ImageSummary) []string {
+	var names []string
+	for _, name := range append(image.RepoTags, image.RepoDigests...) {
+		if !strings.Contains(name, "<none>") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 type byCreated []*types.ImageSummary
 
 func (a byCreated) Len() int           { return len(a) }
@@ -8,7 +8,7 @@ import (
 	"context"
 	"testing"
 
-	"github.com/drone/drone-gc/gc/internal"
+	"github.com/drone/drone-gc/gc"
 	"github.com/google/go-cmp/cmp"
 
 	"docker.io/go-docker/api/types"
@@ -59,13 +59,13 @@ func TestDiskUsage(t *testing.T) {
 	api.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
 
 	c := newCache(100)
-	c.push(internal.ExpandImage("golang:1"), 1192233600)      // newest
-	c.push(internal.ExpandImage("alpine:latest"), 359596800)  // oldest
-	c.push(internal.ExpandImage("busybox:latest"), 420681600) // middle
+	c.push("a180b24e38ed", 1192233600, nil) // newest
+	c.push("4e38e38c8ce0", 359596800, nil)  // oldest
+	c.push("481995377a04", 420681600, nil)  // middle
 
 	s := &client{
-		APIClient: api,
-		cache:     c,
+		Backend: gc.NewDockerBackend(api),
+		cache:   c,
 	}
 
 	got, _ := s.DiskUsage(context.Background())
@@ -0,0 +1,110 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"sync"
+
+	"docker.io/go-docker/api/types"
+)
+
+// growth keeps a running estimate of the image layer size,
+// based on the last disk usage report plus the new bytes of
+// the images pulled since, and signals when the estimate
+// crosses the threshold. The threshold is a function, since
+// it depends on the active profile and the tuner.
+type growth struct {
+	mu sync.Mutex
+
+	threshold func() int64
+	baseline  int64
+	pulled    int64
+	trigger   chan<- struct{}
+
+	// present holds the ids of the images in the last disk
+	// usage report or pulled since.
+	present map[string]bool
+
+	// layers holds the layers of the pulled images that are
+	// still present, by image id.
+	layers map[string][]string
+}
+
+// reset resets the estimate to the layer size reported by
+// the docker daemon.
+func (g *growth) reset(df types.DiskUsage) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.baseline = df.LayersSize
+	g.pulled = 0
+	g.present = map[string]bool{}
+	for _, image := range df.Images {
+		g.present[image.ID] = true
+	}
+	for id := range g.layers {
+		if !g.present[id] {
+			delete(g.layers, id)
+		}
+	}
+}
+
+// add adds the new bytes of a pulled image to the estimate.
+// It returns the estimate, and true if the estimate crossed
+// the threshold and a collection was triggered. A collection
+// is triggered again once the pending collection started and
+// more images are pulled.
+func (g *growth) add(info types.ImageInspect) (int64, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.present[info.ID] {
+		// the image was already pulled.
+		return g.baseline + g.pulled, false
+	}
+	g.pulled += g.unshared(info)
+	if g.present == nil {
+		g.present = map[string]bool{}
+	}
+	if g.layers == nil {
+		g.layers = map[string][]string{}
+	}
+	g.present[info.ID] = true
+	g.layers[info.ID] = info.RootFS.Layers
+
+	estimate := g.baseline + g.pulled
+	if g.trigger == nil || g.threshold == nil || estimate < g.threshold() {
+		return estimate, false
+	}
+	select {
+	case g.trigger <- struct{}{}:
+		return estimate, true
+	default:
+		// a collection is already pending.
+		return estimate, false
+	}
+}
+
+// unshared returns the estimated size of the image layers
+// that are not shared with the images pulled before. Layer
+// sizes are not reported by the image inspection, so the
+// size is estimated in proportion to the number of new
+// layers.
+func (g *growth) unshared(info types.ImageInspect) int64 {
+	if len(info.RootFS.Layers) == 0 {
+		return info.Size
+	}
+	known := map[string]bool{}
+	for _, layers := range g.layers {
+		for _, layer := range layers {
+			known[layer] = true
+		}
+	}
+	var n int64
+	for _, layer := range info.RootFS.Layers {
+		if !known[layer] {
+			n++
+		}
+	}
+	return info.Size * n / int64(len(info.RootFS.Layers))
+}
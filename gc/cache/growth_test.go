@@ -0,0 +1,76 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"testing"
+
+	"docker.io/go-docker/api/types"
+)
+
+func TestGrowth(t *testing.T) {
+	trigger := make(chan struct{}, 1)
+	g := &growth{
+		threshold: func() int64 { return 1000 },
+		trigger:   trigger,
+	}
+	g.reset(types.DiskUsage{LayersSize: 600})
+
+	if estimate, fired := g.add(types.ImageInspect{ID: "a180b24e38ed", Size: 300}); fired || estimate != 900 {
+		t.Errorf("Want estimate 900 below threshold, got %d fired %v", estimate, fired)
+	}
+	if estimate, fired := g.add(types.ImageInspect{ID: "4e38e38c8ce0", Size: 200}); !fired || estimate != 1100 {
+		t.Errorf("Want estimate 1100 to trigger collection, got %d fired %v", estimate, fired)
+	}
+	// the collection is only triggered once while it is
+	// pending.
+	if _, fired := g.add(types.ImageInspect{ID: "481995377a04", Size: 200}); fired {
+		t.Errorf("Want collection triggered once")
+	}
+	if got, want := len(trigger), 1; got != want {
+		t.Errorf("Want %d pending trigger, got %d", want, got)
+	}
+
+	// the collection is triggered again once the pending
+	// collection started, even if it did not report the
+	// disk usage.
+	<-trigger
+	if _, fired := g.add(types.ImageInspect{ID: "6d8c4adbca87", Size: 100}); !fired {
+		t.Errorf("Want collection triggered again")
+	}
+
+	<-trigger
+	g.reset(types.DiskUsage{LayersSize: 500})
+	if _, fired := g.add(types.ImageInspect{ID: "0f5a2ae4b25f", Size: 600}); !fired {
+		t.Errorf("Want collection triggered after reset")
+	}
+}
+
+func TestGrowth_NewBytes(t *testing.T) {
+	g := new(growth)
+	g.reset(types.DiskUsage{
+		LayersSize: 500,
+		Images:     []*types.ImageSummary{{ID: "a180b24e38ed", Size: 500}},
+	})
+
+	// pulling an image that is up to date adds nothing.
+	if estimate, _ := g.add(types.ImageInspect{ID: "a180b24e38ed", Size: 500}); estimate != 500 {
+		t.Errorf("Want estimate unchanged by a no-op pull, got %d", estimate)
+	}
+
+	golang := types.ImageInspect{ID: "4e38e38c8ce0", Size: 800}
+	golang.RootFS.Layers = []string{"sha256:1", "sha256:2", "sha256:3", "sha256:4"}
+	if estimate, _ := g.add(golang); estimate != 1300 {
+		t.Errorf("Want estimate 1300, got %d", estimate)
+	}
+
+	// the image shares three of four layers with the image
+	// pulled before.
+	golang.ID = "481995377a04"
+	golang.RootFS.Layers = []string{"sha256:1", "sha256:2", "sha256:3", "sha256:5"}
+	if estimate, _ := g.add(golang); estimate != 1500 {
+		t.Errorf("Want estimate 1500 counting the new layer only, got %d", estimate)
+	}
+}
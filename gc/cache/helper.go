@@ -6,22 +6,164 @@ package cache
 
 import (
 	"context"
+	"math/rand"
+	"time"
 
-	"docker.io/go-docker"
+	"github.com/drone/drone-gc/gc"
+	"github.com/drone/drone-gc/gc/internal"
+
+	"docker.io/go-docker/api/types/events"
 )
 
-// Wrap returns a wrapped copy of the Docker client that
-// collects details about image use and sorts the disk usage
-// report based on the image last used date, ascending.
-func Wrap(ctx context.Context, api docker.APIClient) docker.APIClient {
-	c := newCache(DefaultCacheSize)
-	l := &listener{
-		client: api,
-		cache:  c,
+// Option configures the cache.
+type Option func(*config)
+
+type config struct {
+	threshold  func() int64
+	trigger    chan<- struct{}
+	now        func() time.Time
+	window     time.Duration
+	maxGap     time.Duration
+	size       int
+	registries internal.Registries
+}
+
+// WithTrigger returns an option to signal the trigger
+// channel when the estimated image layer size crosses the
+// threshold, based on the images pulled since the last disk
+// usage report. The threshold function returns the threshold
+// currently applied by the collector. The send never blocks.
+func WithTrigger(threshold func() int64, trigger chan<- struct{}) Option {
+	return func(c *config) {
+		c.threshold = threshold
+		c.trigger = trigger
+	}
+}
+
+// WithClock returns an option to set the clock used to
+// record the image last used date.
+func WithClock(now func() time.Time) Option {
+	return func(c *config) {
+		c.now = now
 	}
-	go l.listen(ctx)
-	return &client{
-		APIClient: api,
-		cache:     c,
+}
+
+// WithRegretWindow returns an option to set the duration
+// after eviction during which an image pull or use is counted
+// as a regret. Zero disables regret tracking.
+func WithRegretWindow(window time.Duration) Option {
+	return func(c *config) {
+		c.window = window
 	}
 }
+
+// WithSize returns an option to set the number of images
+// tracked by the usage cache. The least recently used image
+// is dropped when the size is reached.
+func WithSize(size int) Option {
+	return func(c *config) {
+		c.size = size
+	}
+}
+
+// WithRegistryAliases returns an option to map registry
+// aliases, such as pull-through mirrors, to the canonical
+// registry, so that image references through any of the
+// registries are tracked as the same image.
+func WithRegistryAliases(aliases map[string]string) Option {
+	return func(c *config) {
+		c.registries = internal.Registries(aliases)
+	}
+}
+
+// WithMaxEventGap returns an option to set the maximum
+// duration the event stream may be disconnected before the
+// image usage is resynchronised from the container list,
+// instead of replaying the missed events. Zero always
+// resynchronises.
+func WithMaxEventGap(gap time.Duration) Option {
+	return func(c *config) {
+		c.maxGap = gap
+	}
+}
+
+// Tracker tracks image use from the runtime event stream.
+type Tracker struct {
+	listener *listener
+	client   *client
+}
+
+// NewTracker returns an image use tracker for the backend.
+func NewTracker(api gc.Backend, opt ...Option) *Tracker {
+	conf := &config{
+		now:    time.Now,
+		window: DefaultRegretWindow,
+		maxGap: DefaultMaxEventGap,
+		size:   DefaultCacheSize,
+	}
+	for _, o := range opt {
+		o(conf)
+	}
+	c := newCache(conf.size)
+	c.registries = conf.registries
+	g := &growth{
+		threshold: conf.threshold,
+		trigger:   conf.trigger,
+	}
+	var r *regrets
+	if conf.window > 0 {
+		r = newRegrets(conf.window, conf.registries)
+	}
+	return &Tracker{
+		listener: &listener{
+			client:   api,
+			cache:    c,
+			growth:   g,
+			regrets:  r,
+			now:      conf.now,
+			minDelay: minReconnectDelay,
+			maxDelay: maxReconnectDelay,
+			maxGap:   conf.maxGap,
+			confirm:  confirmDelay,
+			random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		},
+		client: &client{
+			Backend: api,
+			cache:   c,
+			growth:  g,
+			regrets: r,
+			now:     conf.now,
+		},
+	}
+}
+
+// Backend returns the wrapped backend, which sorts the disk
+// usage report based on the image last used date, ascending.
+func (t *Tracker) Backend() gc.Backend {
+	return t.client
+}
+
+// Listen tracks image use from the runtime event stream until
+// the context is cancelled, reconnecting if disconnected.
+func (t *Tracker) Listen(ctx context.Context) error {
+	return t.listener.listen(ctx)
+}
+
+// Health returns the health of the event stream.
+func (t *Tracker) Health() Health {
+	return t.listener.status()
+}
+
+// Observe tracks image use from the runtime event.
+func (t *Tracker) Observe(ctx context.Context, event events.Message) {
+	t.listener.handle(ctx, event)
+}
+
+// Wrap returns a wrapped copy of the backend that collects
+// details about image use and sorts the disk usage report
+// based on the image last used date, ascending.
+func Wrap(ctx context.Context, api gc.Backend, opt ...Option) gc.Backend {
+	t := NewTracker(api, opt...)
+	go t.Listen(ctx)
+	return t.Backend()
+}
@@ -6,78 +6,436 @@ package cache
 
 import (
 	"context"
+	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/drone/drone-gc/gc/internal"
+	"github.com/drone/drone-gc/gc"
 	"github.com/rs/zerolog/log"
 
-	"docker.io/go-docker"
-	"docker.io/go-docker/api/types"
-	"docker.io/go-docker/api/types/filters"
+	"docker.io/go-docker/api/types/events"
 )
 
+const (
+	// DefaultMaxEventGap is the default maximum duration the
+	// event stream may be disconnected before the usage is
+	// resynchronised from the container list.
+	DefaultMaxEventGap = 5 * time.Minute
+
+	// reconnect backoff bounds.
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+
+	// duration without an error after which the event
+	// stream is considered open.
+	confirmDelay = time.Second
+)
+
+// Health reports the health of the event stream.
+type Health struct {
+	Connected  bool      `json:"connected"`
+	LastEvent  time.Time `json:"last_event"`
+	Reconnects int       `json:"reconnects"`
+	Resyncs    int       `json:"resyncs"`
+	Error      string    `json:"error,omitempty"`
+}
+
 type listener struct {
-	client docker.APIClient
-	cache  *cache
+	client  gc.Backend
+	cache   *cache
+	growth  *growth
+	regrets *regrets
+	now     func() time.Time
+
+	minDelay time.Duration
+	maxDelay time.Duration
+	maxGap   time.Duration
+	confirm  time.Duration
+	random   *rand.Rand
+
+	mu      sync.Mutex
+	health  Health
+	mark    time.Time // events before the mark were received
+	lost    time.Time // time the stream was disconnected
+	refused bool      // a connection was refused since lost
 }
 
 func (l *listener) listen(ctx context.Context) error {
 	// this is an infinite loop that only exites when
 	// the context is cancelled (e.g. graceful shutdown).
 	// we want to continuously re-connect to the docker
-	// event stream if disconnected.
+	// event stream if disconnected, backing off after
+	// consecutive failures.
+	var failures int
 	for {
+		opened, err := l.do(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if opened {
+			failures = 0
+		}
+		failures++
+		delay := l.backoff(failures)
+		l.disconnected(err)
+
+		log.Ctx(ctx).Warn().
+			Err(err).
+			Dur("delay", delay).
+			Msg("disconnected from docker events, reconnecting")
+
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		default:
-			err := l.do(ctx)
-			if err != nil {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case <-time.After(time.Minute):
-					// wait before reconnecting
-				}
-			}
+		case <-time.After(delay):
 		}
 	}
 }
 
-func (l *listener) do(ctx context.Context) error {
+// do subscribes to the event stream, and handles the events
+// until disconnected. It returns true if the stream was open.
+func (l *listener) do(ctx context.Context) (bool, error) {
 	logger := log.Ctx(ctx)
-	logger.Info().
-		Msg("listening for docker events")
+	opts := eventOpts
 
-	eventc, errc := l.client.Events(ctx, eventOpts)
+	l.mu.Lock()
+	mark, lost, refused := l.mark, l.lost, l.refused
+	l.mu.Unlock()
+
+	// events emitted while disconnected are requested since
+	// the last event received. The runtime retains a limited
+	// number of past events, and none across a restart, so
+	// after a long gap or a refused connection the usage is
+	// resynchronised from the container list instead. Events
+	// at the mark may be delivered twice, which only counts
+	// the image use twice.
+	var resync bool
+	if !lost.IsZero() {
+		gap := l.now().Sub(lost)
+		if gap > l.maxGap {
+			resync = true
+			logger.Warn().
+				Dur("gap", gap).
+				Msg("docker event gap too large, resynchronising usage")
+		} else if refused {
+			resync = true
+			logger.Warn().
+				Dur("gap", gap).
+				Msg("docker daemon unavailable, resynchronising usage")
+		} else {
+			opts.Since = mark
+			logger.Info().
+				Dur("gap", gap).
+				Time("since", mark).
+				Msg("reconnecting to docker events")
+		}
+	} else {
+		logger.Info().
+			Msg("listening for docker events")
+	}
+
+	// the runtime reports a failed subscription on the error
+	// channel, so the stream is considered open once the first
+	// event is received, or no error is reported within the
+	// confirmation delay.
+	eventc, errc := l.client.Events(ctx, opts)
+	confirm := time.NewTimer(l.confirm)
+	defer confirm.Stop()
+
+	var opened bool
+	open := func() {
+		if opened {
+			return
+		}
+		opened = true
+		l.connected()
+		if resync {
+			// the usage is resynchronised after subscribing
+			// so that no event is lost in between.
+			l.resync(ctx)
+		}
+	}
 	for {
 		select {
 		case err := <-errc:
-			return err
+			if !opened {
+				l.refuse()
+			}
+			return opened, err
 		case <-ctx.Done():
-			return ctx.Err()
+			return opened, ctx.Err()
+		case <-confirm.C:
+			open()
 		case event := <-eventc:
-			if event.Action == "create" && event.Type == "container" {
-				name := internal.ExpandImage(event.From)
-				l.cache.push(name, time.Now().Unix())
+			open()
+			l.handle(ctx, event)
+			l.received(event)
+		}
+	}
+}
+
+// resync records the images of the containers as used, at the
+// container creation time or now if the container is running.
+func (l *listener) resync(ctx context.Context) {
+	logger := log.Ctx(ctx)
+	containers, err := l.client.ContainerList(ctx)
+	if err != nil {
+		logger.Warn().
+			Err(err).
+			Msg("cannot list containers to resynchronise usage")
+		return
+	}
+	now := l.now().Unix()
+	for _, c := range containers {
+		if c.ImageID == "" {
+			continue
+		}
+		last := c.Created
+		if c.State == "running" {
+			last = now
+		}
+		l.cache.update(c.ImageID, last)
+		if c.Image != "" && !isID(c.Image) {
+			l.cache.alias(c.Image, c.ImageID)
+		}
+	}
+
+	l.mu.Lock()
+	l.health.Resyncs++
+	l.mu.Unlock()
+
+	logger.Info().
+		Int("containers", len(containers)).
+		Msg("usage resynchronised from containers")
+}
+
+// backoff returns the reconnect delay after the number of
+// consecutive failures, with equal jitter.
+func (l *listener) backoff(failures int) time.Duration {
+	delay := l.minDelay
+	for i := 1; i < failures && delay < l.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.maxDelay {
+		delay = l.maxDelay
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Duration(half + l.random.Int63n(half))
+}
+
+func (l *listener) connected() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.lost.IsZero() {
+		l.health.Reconnects++
+	}
+	if l.mark.IsZero() {
+		l.mark = l.now()
+	}
+	l.lost = time.Time{}
+	l.refused = false
+	l.health.Connected = true
+	l.health.Error = ""
+}
+
+func (l *listener) disconnected(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lost.IsZero() {
+		l.lost = l.now()
+	}
+	l.health.Connected = false
+	if err != nil {
+		l.health.Error = err.Error()
+	}
+}
+
+// refuse records a connection that failed before the stream
+// was open, which may be a restart of the runtime.
+func (l *listener) refuse() {
+	l.mu.Lock()
+	l.refused = true
+	l.mu.Unlock()
+}
 
-				logger.Debug().
-					Str("image", event.From).
-					Msg("image used, update cache")
+func (l *listener) received(event events.Message) {
+	t := time.Unix(0, event.TimeNano)
+	if event.TimeNano == 0 {
+		t = time.Unix(event.Time, 0)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.After(l.mark) {
+		l.mark = t
+	}
+	l.health.LastEvent = t
+}
+
+// status returns the event stream health.
+func (l *listener) status() Health {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.health
+}
+
+// handle updates the cache from the runtime event. Container
+// create and start, and image pull and tag events count as
+// image use. Image references are resolved to the image id.
+// Image untag events remove the alias, and delete events
+// remove the image from the cache.
+func (l *listener) handle(ctx context.Context, event events.Message) {
+	switch event.Type {
+	case events.ContainerEventType:
+		switch event.Action {
+		case "create", "start":
+			image := event.From
+			if image == "" {
+				image = event.Actor.Attributes["image"]
+			}
+			l.use(ctx, image, event)
+		}
+	case events.ImageEventType:
+		switch event.Action {
+		case "pull":
+			l.pulled(ctx, event)
+		case "tag":
+			name := event.Actor.Attributes["name"]
+			l.used(ctx, name)
+			l.record(ctx, event.ID, []string{name}, event)
+		case "untag":
+			// the daemon may report the image id instead of
+			// the removed tag, in which case the remaining tags
+			// are kept until the image is deleted.
+			name := event.Actor.Attributes["name"]
+			if name != "" && !isID(name) && l.cache.remove(name) {
+				log.Ctx(ctx).Debug().
+					Str("image", name).
+					Msg("image untagged, update cache")
+			}
+		case "delete":
+			if l.cache.removeID(event.ID) {
+				log.Ctx(ctx).Debug().
+					Str("image", event.ID).
+					Msg("image deleted, update cache")
 			}
 		}
 	}
 }
 
-var eventOpts = types.EventsOptions{
-	Filters: filters.NewArgs(
-		filters.KeyValuePair{
-			Key:   "type",
-			Value: "container",
-		},
-		filters.KeyValuePair{
-			Key:   "event",
-			Value: "create",
-		},
-	),
+// use records the use of the image reference, resolved to the
+// image id from the cache or by inspecting the image.
+func (l *listener) use(ctx context.Context, image string, event events.Message) {
+	if image == "" {
+		return
+	}
+	l.used(ctx, image)
+
+	if isID(image) {
+		l.record(ctx, image, nil, event)
+		return
+	}
+	if id, ok := l.cache.resolve(image); ok {
+		l.record(ctx, id, nil, event)
+		return
+	}
+	info, err := l.client.ImageInspect(ctx, image)
+	if err != nil {
+		log.Ctx(ctx).Warn().
+			Err(err).
+			Str("image", image).
+			Msg("cannot resolve image id")
+		return
+	}
+	l.record(ctx, info.ID, append(info.RepoTags, info.RepoDigests...), event)
+}
+
+// record records the use of the image id, and the image names
+// as aliases.
+func (l *listener) record(ctx context.Context, id string, names []string, event events.Message) {
+	if id == "" {
+		return
+	}
+	l.cache.push(id, l.now().Unix(), event.Actor.Attributes)
+	for _, name := range names {
+		if name != "" && !strings.Contains(name, "<none>") {
+			l.cache.alias(name, id)
+		}
+	}
+
+	log.Ctx(ctx).Debug().
+		Str("image", id).
+		Strs("names", names).
+		Str("type", event.Type).
+		Str("action", event.Action).
+		Interface("attributes", event.Actor.Attributes).
+		Msg("image used, update cache")
+}
+
+// used counts a regret if the image was evicted within the
+// regret window.
+func (l *listener) used(ctx context.Context, image string) {
+	if l.regrets == nil {
+		return
+	}
+	now := l.now()
+	e, ok := l.regrets.used(image, now)
+	if !ok {
+		return
+	}
+	log.Ctx(ctx).Info().
+		Str("image", image).
+		Int64("bytes", e.size).
+		Dur("evicted", now.Sub(e.time)).
+		Msg("evicted image used again")
+}
+
+// pulled records the use of the pulled image, which is
+// inspected since the tag may have moved to a new image id,
+// and adds its new bytes to the running estimate of the image
+// layer size.
+func (l *listener) pulled(ctx context.Context, event events.Message) {
+	logger := log.Ctx(ctx)
+	image := event.ID
+	l.used(ctx, image)
+
+	info, err := l.client.ImageInspect(ctx, image)
+	if err != nil {
+		logger.Warn().
+			Err(err).
+			Str("image", image).
+			Msg("cannot inspect pulled image")
+		return
+	}
+	l.record(ctx, info.ID, append(info.RepoTags, info.RepoDigests...), event)
+
+	if l.growth == nil || l.growth.trigger == nil {
+		return
+	}
+	estimate, fired := l.growth.add(info)
+	logger.Debug().
+		Str("image", image).
+		Int64("size", info.Size).
+		Int64("estimate", estimate).
+		Msg("image pulled, update size estimate")
+	if fired {
+		logger.Info().
+			Str("image", image).
+			Int64("estimate", estimate).
+			Msg("image cache above threshold, collection triggered")
+	}
+}
+
+// isID returns true if the image reference is an image id.
+func isID(name string) bool {
+	return strings.HasPrefix(name, "sha256:")
+}
+
+var eventOpts = gc.EventOptions{
+	Types: []string{"container", "image"},
 }
@@ -3,3 +3,301 @@
 // that can be found in the LICENSE file.
 
 package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+	"github.com/drone/drone-gc/gc/memory"
+	"github.com/drone/drone-gc/mocks"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/events"
+	"github.com/golang/mock/gomock"
+)
+
+func TestListener_Pulled(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().ImageInspectWithRaw(gomock.Any(), "golang:1.12").Return(types.ImageInspect{ID: "sha256:a180b24e38ed", Size: 800}, nil, nil)
+
+	trigger := make(chan struct{}, 1)
+	l := &listener{
+		client: gc.NewDockerBackend(client),
+		cache:  newCache(10),
+		growth: &growth{threshold: func() int64 { return 1000 }, baseline: 500, trigger: trigger},
+		now:    time.Now,
+	}
+	l.handle(context.Background(), events.Message{
+		Type:   events.ImageEventType,
+		Action: "pull",
+		ID:     "golang:1.12",
+	})
+
+	if got, want := len(trigger), 1; got != want {
+		t.Errorf("Want collection triggered")
+	}
+	if _, ok := l.cache.find("sha256:a180b24e38ed"); !ok {
+		t.Errorf("Want pulled image in the cache")
+	}
+}
+
+func TestListener_Handle(t *testing.T) {
+	const digest = "alpine@sha256:e4355b66995c96b4b468159fc5c7e3540fcef961189ca13fee877798649f531a"
+
+	backend := memory.New()
+	backend.AddImage(types.ImageSummary{ID: "sha256:a180b24e38ed", RepoTags: []string{"golang:1.12"}})
+	backend.AddImage(types.ImageSummary{ID: "sha256:8c811b4aec35", RepoDigests: []string{digest}})
+
+	now := time.Unix(1192233600, 0)
+	l := &listener{
+		client: backend,
+		cache:  newCache(10),
+		now:    func() time.Time { return now },
+	}
+	ctx := context.Background()
+	l.handle(ctx, events.Message{
+		Type:   events.ImageEventType,
+		Action: "pull",
+		ID:     "golang:1.12",
+	})
+	l.handle(ctx, events.Message{
+		Type:   events.ImageEventType,
+		Action: "tag",
+		ID:     "sha256:a180b24e38ed",
+		Actor:  events.Actor{ID: "sha256:a180b24e38ed", Attributes: map[string]string{"name": "golang:1"}},
+	})
+	l.handle(ctx, events.Message{
+		Type:   events.ContainerEventType,
+		Action: "start",
+		ID:     "c1",
+		Actor:  events.Actor{ID: "c1", Attributes: map[string]string{"image": digest, "name": "build"}},
+	})
+
+	tests := map[string]string{
+		"golang:1.12": "sha256:a180b24e38ed",
+		"golang:1":    "sha256:a180b24e38ed",
+		digest:        "sha256:8c811b4aec35",
+	}
+	for name, want := range tests {
+		if got, _ := l.cache.resolve(name); got != want {
+			t.Errorf("Want %s resolved to %s, got %q", name, want, got)
+		}
+	}
+	if got, want := l.cache.index["sha256:8c811b4aec35"].Attributes["name"], "build"; got != want {
+		t.Errorf("Want actor attribute %q, got %q", want, got)
+	}
+
+	// untagging removes the alias, but keeps the image.
+	l.handle(ctx, events.Message{
+		Type:   events.ImageEventType,
+		Action: "untag",
+		ID:     "sha256:a180b24e38ed",
+		Actor:  events.Actor{ID: "sha256:a180b24e38ed", Attributes: map[string]string{"name": "golang:1"}},
+	})
+	if _, ok := l.cache.resolve("golang:1"); ok {
+		t.Errorf("Want untagged name removed from the cache")
+	}
+
+	// the tag moves to a new image, and the previous image
+	// keeps its use history.
+	now = now.Add(time.Hour)
+	l.handle(ctx, events.Message{
+		Type:   events.ImageEventType,
+		Action: "tag",
+		ID:     "sha256:20e9c96ea3a0",
+		Actor:  events.Actor{ID: "sha256:20e9c96ea3a0", Attributes: map[string]string{"name": "golang:1.12"}},
+	})
+	if got, _ := l.cache.resolve("golang:1.12"); got != "sha256:20e9c96ea3a0" {
+		t.Errorf("Want moved tag resolved to the new image, got %q", got)
+	}
+	if got, _ := l.cache.find("sha256:a180b24e38ed"); got != 1192233600 {
+		t.Errorf("Want previous image use history kept, got %d", got)
+	}
+
+	l.handle(ctx, events.Message{
+		Type:   events.ImageEventType,
+		Action: "delete",
+		ID:     "sha256:a180b24e38ed",
+	})
+	if _, ok := l.cache.find("sha256:a180b24e38ed"); ok {
+		t.Errorf("Want deleted image removed from the cache")
+	}
+	if _, ok := l.cache.find("sha256:8c811b4aec35"); !ok {
+		t.Errorf("Want other images kept in the cache")
+	}
+}
+
+func TestListener_Reconnect(t *testing.T) {
+	backend := memory.New()
+	l := newTestListener(backend, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.listen(ctx)
+
+	waitFor(t, func() bool { return l.status().Connected })
+	backend.Disconnect()
+	backend.AddImage(types.ImageSummary{ID: "sha256:a180b24e38ed", RepoTags: []string{"golang:1.12"}})
+
+	// the event emitted while disconnected is replayed
+	// after reconnecting.
+	waitFor(t, func() bool {
+		_, ok := l.cache.find("sha256:a180b24e38ed")
+		return ok
+	})
+	health := l.status()
+	if got, want := health.Reconnects, 1; got != want {
+		t.Errorf("Want %d reconnects, got %d", want, got)
+	}
+	if got, want := health.Resyncs, 0; got != want {
+		t.Errorf("Want %d resyncs, got %d", want, got)
+	}
+	if !health.Connected {
+		t.Errorf("Want event stream connected")
+	}
+}
+
+func TestListener_Resync(t *testing.T) {
+	backend := memory.New()
+	l := newTestListener(backend, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.listen(ctx)
+
+	waitFor(t, func() bool { return l.status().Connected })
+	backend.Disconnect()
+	backend.AddImage(types.ImageSummary{ID: "sha256:8c811b4aec35", RepoTags: []string{"alpine:3.8"}})
+	backend.AddContainer(types.Container{ID: "c1", Image: "alpine:3.8", State: "running"})
+
+	// the gap exceeds the maximum, so the usage is
+	// resynchronised from the container list.
+	waitFor(t, func() bool { return l.status().Resyncs == 1 })
+	if _, ok := l.cache.find("sha256:8c811b4aec35"); !ok {
+		t.Errorf("Want container image in the cache after resync")
+	}
+	if got, _ := l.cache.resolve("alpine:3.8"); got != "sha256:8c811b4aec35" {
+		t.Errorf("Want container image name resolved after resync, got %q", got)
+	}
+}
+
+// this test verifies that refused connections are not counted
+// as reconnects, and that the usage is resynchronised once the
+// runtime is available again, since the runtime may have
+// restarted and lost the events emitted while disconnected.
+func TestListener_Refused(t *testing.T) {
+	backend := &refusingBackend{Backend: memory.New()}
+	l := newTestListener(backend, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.listen(ctx)
+
+	waitFor(t, func() bool { return l.status().Connected })
+	backend.refuse(3)
+	backend.Disconnect()
+	backend.AddImage(types.ImageSummary{ID: "sha256:8c811b4aec35", RepoTags: []string{"alpine:3.8"}})
+	backend.AddContainer(types.Container{ID: "c1", Image: "alpine:3.8", State: "running"})
+
+	waitFor(t, func() bool { return l.status().Resyncs == 1 })
+	health := l.status()
+	if got, want := health.Reconnects, 1; got != want {
+		t.Errorf("Want %d reconnects, got %d", want, got)
+	}
+	if !health.Connected {
+		t.Errorf("Want event stream connected")
+	}
+	if got := backend.refused(); got != 3 {
+		t.Errorf("Want 3 refused connections, got %d", got)
+	}
+	if _, ok := l.cache.find("sha256:8c811b4aec35"); !ok {
+		t.Errorf("Want container image in the cache after resync")
+	}
+}
+
+func TestListener_Backoff(t *testing.T) {
+	l := &listener{
+		minDelay: time.Second,
+		maxDelay: time.Minute,
+		random:   rand.New(rand.NewSource(1)),
+	}
+	tests := []struct {
+		failures int
+		max      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{10, time.Minute},
+	}
+	for _, test := range tests {
+		got := l.backoff(test.failures)
+		if got < test.max/2 || got > test.max {
+			t.Errorf("Want delay between %v and %v after %d failures, got %v", test.max/2, test.max, test.failures, got)
+		}
+	}
+}
+
+func newTestListener(backend gc.Backend, maxGap time.Duration) *listener {
+	return &listener{
+		client:   backend,
+		cache:    newCache(10),
+		now:      time.Now,
+		minDelay: 50 * time.Millisecond,
+		maxDelay: 50 * time.Millisecond,
+		maxGap:   maxGap,
+		confirm:  10 * time.Millisecond,
+		random:   rand.New(rand.NewSource(1)),
+	}
+}
+
+// refusingBackend refuses a number of event stream
+// subscriptions, as the runtime does while restarting.
+type refusingBackend struct {
+	*memory.Backend
+
+	mu      sync.Mutex
+	pending int
+	count   int
+}
+
+func (b *refusingBackend) refuse(n int) {
+	b.mu.Lock()
+	b.pending = n
+	b.mu.Unlock()
+}
+
+func (b *refusingBackend) refused() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count
+}
+
+func (b *refusingBackend) Events(ctx context.Context, opts gc.EventOptions) (<-chan events.Message, <-chan error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending == 0 {
+		return b.Backend.Events(ctx, opts)
+	}
+	b.pending--
+	b.count++
+	errc := make(chan error, 1)
+	errc <- errors.New("connection refused")
+	return nil, errc
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+	"github.com/drone/drone-gc/gc/internal"
+
+	"docker.io/go-docker/api/types"
+)
+
+// DefaultRegretWindow is the default duration after eviction
+// during which an image pull or use is counted as a regret.
+const DefaultRegretWindow = time.Hour
+
+// maximum number of worst offenders reported.
+const maxOffenders = 5
+
+// regrets remembers recently evicted image references, and
+// counts the references used again within the window.
+type regrets struct {
+	mu sync.Mutex
+
+	window     time.Duration
+	registries internal.Registries
+	images     map[string]imageRef // by expanded reference
+	evicted    map[string]eviction // by expanded reference
+	evictions  int
+	regrets    int
+	bytes      int64
+	offenders  map[string]*gc.Regret
+}
+
+type imageRef struct {
+	id   string
+	size int64
+}
+
+type eviction struct {
+	imageRef
+	time time.Time
+}
+
+func newRegrets(window time.Duration, registries internal.Registries) *regrets {
+	return &regrets{
+		window:     window,
+		registries: registries,
+		images:     map[string]imageRef{},
+		evicted:    map[string]eviction{},
+		offenders:  map[string]*gc.Regret{},
+	}
+}
+
+// observe records the image id and size of each reference in
+// the disk usage report.
+func (r *regrets) observe(df types.DiskUsage) {
+	images := map[string]imageRef{}
+	for _, image := range df.Images {
+		ref := imageRef{id: image.ID, size: image.Size}
+		images[image.ID] = ref
+		for _, name := range imageNames(image) {
+			images[r.expand(name)] = ref
+		}
+	}
+	r.mu.Lock()
+	r.images = images
+	r.mu.Unlock()
+}
+
+// evict records the evicted image reference. The id is the
+// image id resolved by the cache, and may be empty if unknown.
+// The eviction is counted once per image, regardless of the
+// number of evicted references, like the regrets.
+func (r *regrets) evict(name, id string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(now)
+	name = r.expand(name)
+	ref, ok := r.images[name]
+	if !ok {
+		if id == "" {
+			id = name
+		}
+		ref = imageRef{id: id}
+	}
+	counted := false
+	for _, v := range r.evicted {
+		if v.id == ref.id {
+			counted = true
+			break
+		}
+	}
+	r.evicted[name] = eviction{imageRef: ref, time: now}
+	if !counted {
+		r.evictions++
+	}
+}
+
+// used returns the regret and true if the image reference
+// was evicted within the window.
+func (r *regrets) used(name string, now time.Time) (eviction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(now)
+	name = r.expand(name)
+	e, ok := r.evicted[name]
+	if !ok {
+		return e, false
+	}
+	// the image is downloaded once, regardless of the
+	// number of evicted references.
+	for k, v := range r.evicted {
+		if v.id == e.id {
+			delete(r.evicted, k)
+		}
+	}
+	r.regrets++
+	r.bytes += e.size
+	offender, ok := r.offenders[name]
+	if !ok {
+		offender = &gc.Regret{Image: name}
+		r.offenders[name] = offender
+	}
+	offender.Count++
+	offender.Bytes += e.size
+	return e, true
+}
+
+// expire forgets evictions older than the window. The lock
+// must be held.
+func (r *regrets) expire(now time.Time) {
+	for k, v := range r.evicted {
+		if now.Sub(v.time) > r.window {
+			delete(r.evicted, k)
+		}
+	}
+}
+
+// stats returns the regret statistics.
+func (r *regrets) stats() gc.RegretStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := gc.RegretStats{
+		Evictions: r.evictions,
+		Regrets:   r.regrets,
+		Bytes:     r.bytes,
+	}
+	for _, v := range r.offenders {
+		stats.Worst = append(stats.Worst, *v)
+	}
+	sort.Slice(stats.Worst, func(i, j int) bool {
+		a, b := stats.Worst[i], stats.Worst[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Image < b.Image
+	})
+	if len(stats.Worst) > maxOffenders {
+		stats.Worst = stats.Worst[:maxOffenders]
+	}
+	return stats
+}
+
+// expand returns the fully qualified image name or digest,
+// unless the reference is an image id.
+func (r *regrets) expand(name string) string {
+	if isID(name) {
+		return name
+	}
+	return r.registries.Expand(name)
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+	"github.com/drone/drone-gc/gc/memory"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/events"
+)
+
+func TestRegret(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1546300800, 0)
+	clock := func() time.Time { return now }
+
+	backend := memory.New()
+	backend.AddImage(types.ImageSummary{ID: "sha256:a180b24e38ed", RepoTags: []string{"golang:1", "golang:latest"}, Size: 100})
+	backend.AddImage(types.ImageSummary{ID: "sha256:481995377a04", RepoTags: []string{"redis:5"}, Size: 50})
+
+	tracker := NewTracker(backend, WithClock(clock), WithRegretWindow(time.Hour))
+	client := tracker.Backend()
+	client.DiskUsage(ctx)
+	client.ImageRemove(ctx, "golang:1", types.ImageRemoveOptions{})
+	client.ImageRemove(ctx, "golang:latest", types.ImageRemoveOptions{})
+	client.ImageRemove(ctx, "redis:5", types.ImageRemoveOptions{})
+
+	// the golang image is pulled again within the window,
+	// and the redis image after the window.
+	now = now.Add(time.Minute)
+	tracker.Observe(ctx, events.Message{Type: "image", Action: "pull", ID: "golang:latest"})
+	tracker.Observe(ctx, events.Message{Type: "container", Action: "create", From: "golang:1"})
+	now = now.Add(2 * time.Hour)
+	tracker.Observe(ctx, events.Message{Type: "image", Action: "pull", ID: "redis:5"})
+
+	// the golang image is evicted once, regardless of the
+	// number of removed tags.
+	stats := client.(gc.RegretTracker).Regret()
+	if got, want := stats.Evictions, 2; got != want {
+		t.Errorf("Want %d evictions, got %d", want, got)
+	}
+	if got, want := stats.Regrets, 1; got != want {
+		t.Errorf("Want %d regrets, got %d", want, got)
+	}
+	if got, want := stats.Bytes, int64(100); got != want {
+		t.Errorf("Want %d bytes downloaded again, got %d", want, got)
+	}
+	if len(stats.Worst) != 1 || stats.Worst[0].Image != "docker.io/library/golang:latest" {
+		t.Errorf("Want golang image reported as worst offender, got %v", stats.Worst)
+	}
+}
+
+func TestRegret_Disabled(t *testing.T) {
+	tracker := NewTracker(memory.New(), WithRegretWindow(0))
+	if stats := tracker.Backend().(gc.RegretTracker).Regret(); stats.Evictions != 0 {
+		t.Errorf("Want regret tracking disabled")
+	}
+}
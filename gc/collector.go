@@ -7,9 +7,14 @@ package gc
 import (
 	"context"
 	"docker.io/go-docker/api/types"
+	"fmt"
+	"runtime/debug"
 	"time"
 
-	"docker.io/go-docker"
+	"github.com/drone/drone-gc/gc/audit"
+	"github.com/drone/drone-gc/gc/internal"
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog/log"
 )
 
 // FilterFunc filters the Docker resource based
@@ -26,49 +31,161 @@ type Collector interface {
 }
 
 type collector struct {
-	client docker.APIClient
+	client  Backend
+	auditor audit.Logger
+	host    string
+	now     func() time.Time
 
 	whitelist                   []string // reserved containers
+	networkWhitelist            []string // reserved networks
+	volumeWhitelist             []string // reserved volumes
 	reserved                    []string // reserved images
 	threshold                   int64    // target threshold in bytes
+	registries                  internal.Registries
+	unpullable                  string
+	registry                    Registry
+	pullCost                    *PullCost
+	pullCostWeight              float64
 	minImageAge                 time.Duration
+	containerTTL                time.Duration
+	networkTTL                  time.Duration
+	volumeTTL                   time.Duration
+	malformed                   string
 	filter                      FilterFunc
 	imageRemoveOptions          types.ImageRemoveOptions
 	shouldCollectDanglingImages bool
+	profiles                    []Profile
+	profileName                 string
+	deferral                    *deferral
+	concurrency                 int
+	limiter                     *limiter
+	budget                      Budget
+	spent                       *spending
+	breaker                     *Breaker
+	tuner                       *Tuner
 }
 
-// New returns a garbage collector.
-func New(client docker.APIClient, opt ...Option) Collector {
+// New returns a garbage collector for the container runtime
+// backend.
+func New(client Backend, opt ...Option) Collector {
 	c := new(collector)
 	c.client = client
+	c.auditor = audit.Discard
+	c.now = time.Now
+	c.profileName = DefaultProfile
+	c.spent = new(spending)
 	for _, o := range opt {
 		o(c)
 	}
 	return c
 }
 
+// Threshold returns the image cache threshold the collector
+// applies at the current time, which depends on the active
+// profile and the threshold tuner. It returns zero if the
+// collector was not created by New.
+func Threshold(v Collector) int64 {
+	c, ok := v.(*collector)
+	if !ok {
+		return 0
+	}
+	threshold := c.profile(c.now()).threshold
+	if c.tuner != nil {
+		threshold = c.tuner.threshold(threshold)
+	}
+	return threshold
+}
+
 func (c *collector) Collect(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	c.collectContainers(ctx)
-	if c.shouldCollectDanglingImages {
-		c.collectDanglingImages(ctx)
+
+	start := time.Now()
+	c = c.profile(c.now())
+	c.spent = new(spending)
+	configured := c.threshold
+	if c.tuner != nil {
+		c.threshold = c.tuner.threshold(c.threshold)
+	}
+	logger := log.Ctx(ctx).With().
+		Str("profile", c.profileName).
+		Logger()
+	ctx = logger.WithContext(ctx)
+	logger.Debug().
+		Int64("threshold", c.threshold).
+		Dur("min-image-age", c.minImageAge).
+		Bool("dangling", c.shouldCollectDanglingImages).
+		Msg("collection cycle started")
+
+	var result error
+	if err := c.phase(ctx, "containers", c.collectContainers); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if imageEvictionEnabled(ctx) && !c.deferImageEviction(ctx) {
+		if c.shouldCollectDanglingImages {
+			if err := c.phase(ctx, "dangling-images", c.collectDanglingImages); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+		if err := c.phase(ctx, "images", c.collectImages); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if err := c.phase(ctx, "networks", c.collectNetworks); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := c.phase(ctx, "volumes", c.collectVolumes); err != nil {
+		result = multierror.Append(result, err)
 	}
-	c.collectImages(ctx)
-	c.collectNetworks(ctx)
-	c.collectVolumes(ctx)
-	return nil
+
+	c.logRegret(ctx)
+	c.tune(ctx, configured)
+	logger.Info().
+		Dur("duration", time.Since(start)).
+		Bool("success", result == nil).
+		Msg("collection cycle complete")
+	return result
+}
+
+// phase executes a single collection phase, recovering from
+// panics so that a failing phase does not prevent the
+// remaining phases from running. Resource errors are logged
+// and audited by the phase, and are not returned.
+func (c *collector) phase(ctx context.Context, name string, fn func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s phase panic: %v", name, r)
+			log.Ctx(ctx).Error().
+				Str("phase", name).
+				Str("panic", fmt.Sprint(r)).
+				Str("stack", string(debug.Stack())).
+				Msg("recovered from panic")
+		}
+	}()
+	return phaseErrors(fn(ctx))
+}
+
+// resourceError is the failure to inspect or remove a single
+// resource, for example because it is still in use. Resource
+// errors are expected, and do not fail the collection cycle.
+type resourceError struct {
+	error
 }
 
-// Schedule schedules the garbage collector to execute at the
-// specified interval duration.
-func Schedule(ctx context.Context, collector Collector, interval time.Duration) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(interval):
-			collector.Collect(ctx)
+// phaseErrors returns the errors, excluding resource errors.
+func phaseErrors(err error) error {
+	switch err := err.(type) {
+	case resourceError:
+		return nil
+	case *multierror.Error:
+		var result error
+		for _, e := range err.Errors {
+			if _, ok := e.(resourceError); !ok {
+				result = multierror.Append(result, e)
+			}
 		}
+		return result
+	default:
+		return err
 	}
 }
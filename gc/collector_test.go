@@ -0,0 +1,56 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+func TestPhase(t *testing.T) {
+	c := New(nil).(*collector)
+
+	mockErr := errors.New("cannot list volumes")
+	err := c.phase(context.Background(), "volumes", func(context.Context) error {
+		return mockErr
+	})
+	if err != mockErr {
+		t.Errorf("Want phase error returned, got %v", err)
+	}
+
+	err = c.phase(context.Background(), "networks", func(context.Context) error {
+		var labels map[string]string
+		labels["io.drone.expires"] = "915148800"
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Want error returned from recovered panic")
+	}
+
+	// resource errors, such as removing an image that is
+	// still in use, do not fail the phase.
+	err = c.phase(context.Background(), "images", func(context.Context) error {
+		var result error
+		result = multierror.Append(result, resourceError{errors.New("image is in use")})
+		result = multierror.Append(result, resourceError{errors.New("image is in use")})
+		return result
+	})
+	if err != nil {
+		t.Errorf("Want resource errors ignored, got %v", err)
+	}
+
+	err = c.phase(context.Background(), "images", func(context.Context) error {
+		var result error
+		result = multierror.Append(result, resourceError{errors.New("image is in use")})
+		result = multierror.Append(result, ErrBreakerOpen)
+		return result
+	})
+	if merr, ok := err.(*multierror.Error); !ok || len(merr.Errors) != 1 || merr.Errors[0] != ErrBreakerOpen {
+		t.Errorf("Want breaker error returned, got %v", err)
+	}
+}
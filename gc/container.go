@@ -6,6 +6,10 @@ package gc
 
 import (
 	"context"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-gc/gc/audit"
 
 	"docker.io/go-docker/api/types"
 	"github.com/hashicorp/go-multierror"
@@ -13,10 +17,11 @@ import (
 )
 
 func (c *collector) collectContainers(ctx context.Context) error {
+	var mu sync.Mutex
 	var result error
 
 	logger := log.Ctx(ctx)
-	containers, err := c.client.ContainerList(ctx, containerListArgs)
+	containers, err := c.client.ContainerList(ctx)
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -24,12 +29,14 @@ func (c *collector) collectContainers(ctx context.Context) error {
 		return err
 	}
 
+	now := c.now()
+	pool := c.newPool()
 	for _, cc := range containers {
-		if skipImage(cc.Image) {
+		if skipImage(cc.Image, c.registries) {
 			continue
 		}
 
-		if matchPatterns(cc.Names, c.whitelist) {
+		if matchPatterns(cc.Names, c.whitelist, c.registries) {
 			continue
 		}
 
@@ -40,55 +47,104 @@ func (c *collector) collectContainers(ctx context.Context) error {
 			continue
 		}
 
-		if isExpired(cc.Labels) == false {
+		// running containers are only killed if they expire
+		// by label, and not by the default time to live.
+		ttl := c.containerTTL
+		if isRunning(cc.State) {
+			ttl = 0
+		}
+		expired, reason := c.expired(ctx, cc.ID, cc.Labels, time.Unix(cc.Created, 0), ttl, now)
+		if expired == false {
 			logger.Debug().
 				Strs("name", cc.Names).
 				Msg("container not expired")
 			continue
 		}
 
-		if cc.State != "exited" {
-			logger.Debug().
-				Strs("name", cc.Names).
-				Msg("kill long-running container")
-
-			err = c.client.ContainerKill(ctx, cc.ID, "SIGKILL")
-			if err != nil {
-				logger.Error().
-					Err(err).
-					Strs("name", cc.Names).
-					Msg("cannot kill container")
+		if !c.reserveContainer() {
+			logger.Info().
+				Int("containers", c.budget.Containers).
+				Msg("container removal budget exhausted")
+			break
+		}
+
+		cc := cc
+		started := pool.run(ctx, func() {
+			if err := c.removeContainer(ctx, cc, reason); err != nil {
+				c.releaseContainer()
+				mu.Lock()
+				result = multierror.Append(result, resourceError{err})
+				mu.Unlock()
 			}
+		})
+		if !started {
+			c.releaseContainer()
+			break
 		}
+	}
+	pool.wait()
+	return result
+}
+
+// isRunning returns true if the container state is running,
+// paused or restarting.
+func isRunning(state string) bool {
+	switch state {
+	case "running", "paused", "restarting":
+		return true
+	default:
+		return false
+	}
+}
 
-		logger.Info().
+// removeContainer kills the container if it is still running
+// and then removes it.
+func (c *collector) removeContainer(ctx context.Context, cc types.Container, reason expiry) error {
+	logger := log.Ctx(ctx)
+	if cc.State != "exited" {
+		logger.Debug().
 			Strs("name", cc.Names).
-			Msg("remove container")
+			Msg("kill long-running container")
 
-		err := c.client.ContainerRemove(ctx, cc.ID, containerRemoveOpts)
+		err := c.client.ContainerKill(ctx, cc.ID)
+		c.audit(ctx, containerRecord(cc, "kill", reason), err)
 		if err != nil {
 			logger.Error().
 				Err(err).
 				Strs("name", cc.Names).
-				Msg("cannot remove container")
-
-			result = multierror.Append(result, err)
-			continue
+				Msg("cannot kill container")
 		}
+	}
+
+	logger.Info().
+		Strs("name", cc.Names).
+		Msg("remove container")
 
-		logger.Info().
+	err := c.client.ContainerRemove(ctx, cc.ID)
+	c.audit(ctx, containerRecord(cc, "remove", reason), err)
+	if err != nil {
+		logger.Error().
+			Err(err).
 			Strs("name", cc.Names).
-			Msg("successfully removed container")
+			Msg("cannot remove container")
+		return err
 	}
-	return result
-}
 
-var containerListArgs = types.ContainerListOptions{
-	All: true,
+	logger.Info().
+		Strs("name", cc.Names).
+		Msg("successfully removed container")
+	return nil
 }
 
-var containerRemoveOpts = types.ContainerRemoveOptions{
-	RemoveVolumes: true,
-	RemoveLinks:   false,
-	Force:         true,
+func containerRecord(cc types.Container, action string, reason expiry) audit.Record {
+	return audit.Record{
+		Action: action,
+		Kind:   "container",
+		ID:     cc.ID,
+		Names:  cc.Names,
+		Labels: cc.Labels,
+		Size:   cc.SizeRw,
+		Policy: reason.policy,
+		Detail: reason.detail,
+	}
 }
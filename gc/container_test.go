@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/drone/drone-gc/gc/audit"
 	"github.com/drone/drone-gc/mocks"
 
 	"docker.io/go-docker/api/types"
@@ -68,7 +69,7 @@ func TestCollectContainers(t *testing.T) {
 	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil)
 	client.EXPECT().ContainerRemove(gomock.Any(), mockContainers[0].ID, containerRemoveOpts).Return(nil)
 
-	c := New(client,
+	c := New(NewDockerBackend(client),
 		WithWhitelist([]string{"foo"}),
 	).(*collector)
 	err := c.collectContainers(context.Background())
@@ -77,6 +78,43 @@ func TestCollectContainers(t *testing.T) {
 	}
 }
 
+// this test verifies that the default time to live removes an
+// old exited container, but not an old running container.
+func TestCollectContainers_DefaultTTL(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	now := time.Unix(1192233600, 0)
+	old := now.Add(-25 * time.Hour).Unix()
+	mockContainers := []types.Container{
+		{ID: "c3d2a6307f4e", Names: []string{"bar"}, State: "exited", Created: old},
+		// skip recently created containers
+		{ID: "2b8fd9751c4c", Names: []string{"bar"}, State: "exited", Created: now.Add(-time.Hour).Unix()},
+		// skip whitelisted name
+		{ID: "4e38e38c8ce0", Names: []string{"foo"}, State: "exited", Created: old},
+		// skip protected containers
+		{ID: "481995377a04", Names: []string{"bar"}, State: "exited", Created: old, Labels: map[string]string{"io.drone.protected": "true"}},
+		// skip running containers
+		{ID: "a180b24e38ed", Names: []string{"bar"}, State: "running", Created: old},
+		// the expires label takes precedence
+		{ID: "6d8c4adbca87", Names: []string{"bar"}, State: "exited", Created: old, Labels: map[string]string{"io.drone.expires": fmt.Sprint(time.Now().Add(time.Hour).Unix())}},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil)
+	client.EXPECT().ContainerRemove(gomock.Any(), mockContainers[0].ID, containerRemoveOpts).Return(nil)
+
+	c := New(NewDockerBackend(client),
+		WithWhitelist([]string{"foo"}),
+		WithContainerTTL(24*time.Hour),
+		WithClock(func() time.Time { return now }),
+	).(*collector)
+	err := c.collectContainers(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestCollectContainers_MultiError(t *testing.T) {
 	controller := gomock.NewController(t)
 	defer controller.Finish()
@@ -104,9 +142,56 @@ func TestCollectContainers_MultiError(t *testing.T) {
 	client.EXPECT().ContainerRemove(gomock.Any(), mockContainers[0].ID, containerRemoveOpts).Return(mockErr)
 	client.EXPECT().ContainerRemove(gomock.Any(), mockContainers[1].ID, containerRemoveOpts).Return(nil)
 
-	c := New(client).(*collector)
+	c := New(NewDockerBackend(client)).(*collector)
 	err := c.collectContainers(context.Background())
 	if err == nil {
 		t.Errorf("Expected multi-error returned")
 	}
 }
+
+func TestCollectContainers_Audit(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockContainers := []types.Container{
+		{
+			ID:      "c3d2a6307f4e",
+			Names:   []string{"bar"},
+			State:   "running",
+			Labels:  map[string]string{"io.drone.expires": "915148800"},
+			Created: 359596800,
+		},
+	}
+	mockErr := errors.New("cannot remove contianer")
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil)
+	client.EXPECT().ContainerKill(gomock.Any(), mockContainers[0].ID, "SIGKILL").Return(nil)
+	client.EXPECT().ContainerRemove(gomock.Any(), mockContainers[0].ID, containerRemoveOpts).Return(mockErr)
+
+	auditor := new(auditRecorder)
+	c := New(NewDockerBackend(client), WithAuditLog(auditor)).(*collector)
+	c.collectContainers(context.Background())
+
+	if got, want := len(auditor.records), 2; got != want {
+		t.Fatalf("Want %d audit records, got %d", want, got)
+	}
+	if got, want := auditor.records[0].Action, "kill"; got != want {
+		t.Errorf("Want audit action %q, got %q", want, got)
+	}
+	if got, want := auditor.records[1].Outcome, audit.OutcomeFailure; got != want {
+		t.Errorf("Want audit outcome %q, got %q", want, got)
+	}
+	if got, want := auditor.records[1].Error, mockErr.Error(); got != want {
+		t.Errorf("Want audit error %q, got %q", want, got)
+	}
+}
+
+type auditRecorder struct {
+	records []audit.Record
+}
+
+func (a *auditRecorder) Log(r audit.Record) error {
+	a.records = append(a.records, r)
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/drone/drone-gc/gc/internal"
+
+	"docker.io/go-docker/api/types"
+)
+
+// default pull cost model.
+const (
+	defaultPullOverhead   = 5 * time.Second
+	defaultPullThroughput = 50 * 1000 * 1000 // bytes per second
+)
+
+// PullCost estimates the time to pull an image again, as a
+// fixed overhead plus the image size divided by the registry
+// throughput.
+type PullCost struct {
+	// Overhead is the fixed cost of a pull, such as registry
+	// round trips and layer extraction.
+	Overhead time.Duration
+
+	// Throughput is the default pull rate in bytes per
+	// second.
+	Throughput int64
+
+	// Registries overrides the throughput by registry host,
+	// for example nvcr.io.
+	Registries map[string]int64
+}
+
+// WithPullCost returns an option to weigh the image eviction
+// order by the estimated cost of pulling the image again per
+// byte freed. The weight is the exponent applied to the bytes
+// freed per second of pull time, where zero orders images by
+// last use only and one weighs last use and pull cost equally.
+func WithPullCost(cost PullCost, weight float64) Option {
+	return func(c *collector) {
+		if cost.Overhead == 0 {
+			cost.Overhead = defaultPullOverhead
+		}
+		if cost.Throughput <= 0 {
+			cost.Throughput = defaultPullThroughput
+		}
+		c.pullCost = &cost
+		c.pullCostWeight = weight
+	}
+}
+
+// weigh returns the images ordered by eviction score, highest
+// first. The score is the time since the image was last used
+// divided by the number of uses, multiplied by the weighted
+// bytes freed per second of pull time, so that idle and rarely
+// used images that are cheap to pull again per byte freed are
+// evicted first. The order of images with the same score is
+// preserved.
+func (c *collector) weigh(images []*types.ImageSummary) []*types.ImageSummary {
+	if c.pullCost == nil || c.pullCostWeight == 0 {
+		return images
+	}
+	now := c.now()
+	scores := make(map[*types.ImageSummary]float64, len(images))
+	for _, image := range images {
+		idle := now.Sub(time.Unix(image.Created, 0)).Seconds()
+		if idle < 1 {
+			idle = 1
+		}
+		pull := c.pullCost.estimate(image, c.registries).Seconds()
+		freed := float64(imageFreed(c, image))
+		if freed < 1 {
+			freed = 1
+		}
+		uses := float64(c.uses(image))
+		scores[image] = idle / uses * math.Pow(freed/pull, c.pullCostWeight)
+	}
+	out := append([]*types.ImageSummary(nil), images...)
+	sort.SliceStable(out, func(i, j int) bool {
+		return scores[out[i]] > scores[out[j]]
+	})
+	return out
+}
+
+// estimate returns the estimated time to pull the image.
+func (p *PullCost) estimate(image *types.ImageSummary, registries internal.Registries) time.Duration {
+	throughput := p.Throughput
+	for _, name := range append(image.RepoTags, image.RepoDigests...) {
+		if v, ok := p.Registries[internal.Domain(registries.Expand(name))]; ok && v > 0 {
+			throughput = v
+			break
+		}
+	}
+	transfer := time.Duration(float64(image.Size) / float64(throughput) * float64(time.Second))
+	return p.Overhead + transfer
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"testing"
+	"time"
+
+	"docker.io/go-docker/api/types"
+)
+
+func TestWeigh(t *testing.T) {
+	now := time.Unix(1192233600, 0)
+	hour := now.Add(-time.Hour).Unix()
+	images := []*types.ImageSummary{
+		{ID: "6d8c4adbca87", Size: 5e6, Created: now.Add(-10 * time.Hour).Unix(), RepoTags: []string{"redis:latest"}},
+		{ID: "a180b24e38ed", Size: 5e9, Created: hour, RepoTags: []string{"nvcr.io/nvidia/cuda:10.0"}},
+		{ID: "4e38e38c8ce0", Size: 5e6, Created: hour, RepoTags: []string{"alpine:latest"}},
+		{ID: "481995377a04", Size: 800e6, Created: hour, RepoTags: []string{"golang:1"}},
+	}
+	cost := PullCost{
+		Overhead:   5 * time.Second,
+		Throughput: 50e6,
+		Registries: map[string]int64{"nvcr.io": 5e6},
+	}
+
+	tests := []struct {
+		weight float64
+		want   []string
+	}{
+		// last use only.
+		{0, []string{"6d8c4adbca87", "a180b24e38ed", "4e38e38c8ce0", "481995377a04"}},
+		// the large image from the fast registry is the
+		// cheapest to pull again per byte freed, and small
+		// images are expensive due to the pull overhead.
+		{1, []string{"481995377a04", "6d8c4adbca87", "a180b24e38ed", "4e38e38c8ce0"}},
+	}
+	for _, test := range tests {
+		c := New(nil,
+			WithPullCost(cost, test.weight),
+			WithClock(func() time.Time { return now }),
+		).(*collector)
+		var got []string
+		for _, image := range c.weigh(images) {
+			got = append(got, image.ID)
+		}
+		for i := range test.want {
+			if i >= len(got) || got[i] != test.want[i] {
+				t.Errorf("Want order %v with weight %v, got %v", test.want, test.weight, got)
+				break
+			}
+		}
+	}
+}
+
+// this test verifies that frequently used images are evicted
+// after idle images with the same pull cost.
+func TestWeigh_Uses(t *testing.T) {
+	now := time.Unix(1192233600, 0)
+	images := []*types.ImageSummary{
+		{ID: "6d8c4adbca87", Size: 5e6, Created: now.Add(-2 * time.Hour).Unix(), RepoTags: []string{"redis:latest"}},
+		{ID: "4e38e38c8ce0", Size: 5e6, Created: now.Add(-time.Hour).Unix(), RepoTags: []string{"alpine:latest"}},
+	}
+	backend := &usageBackend{hits: map[string]int{"6d8c4adbca87": 4}}
+	c := New(backend,
+		WithPullCost(PullCost{}, 1),
+		WithClock(func() time.Time { return now }),
+	).(*collector)
+	got := c.weigh(images)
+	if got[0].ID != "4e38e38c8ce0" {
+		t.Errorf("Want the rarely used image first, got %s", got[0].ID)
+	}
+}
+
+func TestPullCost_Estimate(t *testing.T) {
+	cost := PullCost{
+		Overhead:   5 * time.Second,
+		Throughput: 50e6,
+		Registries: map[string]int64{"docker.io": 100e6},
+	}
+	image := &types.ImageSummary{Size: 1e9, RepoTags: []string{"mirror.internal/library/golang:1"}}
+	if got, want := cost.estimate(image, nil), 25*time.Second; got != want {
+		t.Errorf("Want estimate %v, got %v", want, got)
+	}
+	// the registry alias uses the throughput of the
+	// canonical registry.
+	registries := map[string]string{"mirror.internal": "docker.io"}
+	if got, want := cost.estimate(image, registries), 15*time.Second; got != want {
+		t.Errorf("Want estimate %v with registry alias, got %v", want, got)
+	}
+}
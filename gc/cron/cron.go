@@ -0,0 +1,203 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+// Package cron parses standard five-field cron expressions.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expression is a parsed cron expression.
+type Expression struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// anyDom and anyDow are true when the day of month and
+	// day of week fields are unrestricted.
+	anyDom bool
+	anyDow bool
+}
+
+// macros supported in place of a five field expression.
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// Parse parses a five field cron expression (minute, hour,
+// day of month, month, day of week) or one of the @hourly,
+// @daily, @weekly, @monthly and @yearly macros.
+func Parse(expr string) (*Expression, error) {
+	expr = strings.TrimSpace(expr)
+	if m, ok := macros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, found %d: %q", len(fields), expr)
+	}
+
+	e := new(Expression)
+	var err error
+	if e.minute, err = parseField(fields[0], minutes); err != nil {
+		return nil, err
+	}
+	if e.hour, err = parseField(fields[1], hours); err != nil {
+		return nil, err
+	}
+	if e.dom, err = parseField(fields[2], doms); err != nil {
+		return nil, err
+	}
+	if e.month, err = parseField(fields[3], months); err != nil {
+		return nil, err
+	}
+	if e.dow, err = parseField(fields[4], dows); err != nil {
+		return nil, err
+	}
+	// sunday can be expressed as either 0 or 7.
+	if e.dow&(1<<7) != 0 {
+		e.dow |= 1
+	}
+	e.anyDom = fields[2] == "*" || fields[2] == "?"
+	e.anyDow = fields[4] == "*" || fields[4] == "?"
+	return e, nil
+}
+
+// Next returns the first activation time strictly after t,
+// evaluated in the location of t. The zero time is returned
+// if no activation time exists within the next five years.
+func (e *Expression) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if e.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !e.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if e.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if e.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchDay follows the cron convention: when both the day of
+// month and the day of week are restricted, a day matches if
+// either field matches.
+func (e *Expression) matchDay(t time.Time) bool {
+	dom := e.dom&(1<<uint(t.Day())) != 0
+	dow := e.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case e.anyDom && e.anyDow:
+		return true
+	case e.anyDom:
+		return dow
+	case e.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("cron: invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		var lo, hi int
+		switch {
+		case part == "*" || part == "?":
+			lo, hi = b.min, b.max
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if lo, err = parseValue(part[:i], b); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(part[i+1:], b); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if lo, err = parseValue(part, b); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if step > 1 {
+				hi = b.max
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("cron: invalid range %q", part)
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, b bounds) (int, error) {
+	if n, ok := b.names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("cron: invalid value %q", s)
+	}
+	if n < b.min || n > b.max {
+		return 0, fmt.Errorf("cron: value %d out of range [%d, %d]", n, b.min, b.max)
+	}
+	return n, nil
+}
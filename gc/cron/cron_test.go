@@ -0,0 +1,65 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2019, time.March, 1, 10, 7, 30, 0, time.UTC) // friday
+	var tests = []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/10 * * * *", time.Date(2019, time.March, 1, 10, 10, 0, 0, time.UTC)},
+		{"* * * * *", time.Date(2019, time.March, 1, 10, 8, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2019, time.March, 1, 11, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2019, time.March, 1, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2019, time.March, 2, 2, 30, 0, 0, time.UTC)},
+		{"0 22-23,0-5 * * *", time.Date(2019, time.March, 1, 22, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2019, time.March, 4, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2019, time.March, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("Cannot parse %q: %s", test.expr, err)
+			continue
+		}
+		if got, want := expr.Next(from), test.want; !got.Equal(want) {
+			t.Errorf("Want %q next run at %s, got %s", test.expr, want, got)
+		}
+	}
+}
+
+func TestNext_Location(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	expr, _ := Parse("0 3 * * *")
+	from := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC).In(loc)
+	want := time.Date(2019, time.March, 1, 1, 0, 0, 0, time.UTC)
+	if got := expr.Next(from); !got.Equal(want) {
+		t.Errorf("Want next run at %s, got %s", want, got)
+	}
+}
+
+func TestParse_Error(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Want error parsing %q", expr)
+		}
+	}
+}
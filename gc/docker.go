@@ -0,0 +1,114 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"docker.io/go-docker"
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/events"
+	"docker.io/go-docker/api/types/filters"
+)
+
+// NewDockerBackend returns a Backend for the Docker client.
+func NewDockerBackend(client docker.APIClient) Backend {
+	return &dockerBackend{client: client}
+}
+
+type dockerBackend struct {
+	client docker.APIClient
+}
+
+func (b *dockerBackend) ContainerList(ctx context.Context) ([]types.Container, error) {
+	return b.client.ContainerList(ctx, containerListArgs)
+}
+
+func (b *dockerBackend) ContainerKill(ctx context.Context, id string) error {
+	return b.client.ContainerKill(ctx, id, "SIGKILL")
+}
+
+func (b *dockerBackend) ContainerRemove(ctx context.Context, id string) error {
+	return b.client.ContainerRemove(ctx, id, containerRemoveOpts)
+}
+
+func (b *dockerBackend) ImageInspect(ctx context.Context, image string) (types.ImageInspect, error) {
+	info, _, err := b.client.ImageInspectWithRaw(ctx, image)
+	return info, err
+}
+
+func (b *dockerBackend) ImageRemove(ctx context.Context, image string, opts types.ImageRemoveOptions) error {
+	_, err := b.client.ImageRemove(ctx, image, opts)
+	return err
+}
+
+func (b *dockerBackend) ImagesPrune(ctx context.Context, until time.Duration) (types.ImagesPruneReport, error) {
+	return b.client.ImagesPrune(ctx, imagePruneArgs(until))
+}
+
+func (b *dockerBackend) NetworkList(ctx context.Context) ([]types.NetworkResource, error) {
+	return b.client.NetworkList(ctx, types.NetworkListOptions{})
+}
+
+func (b *dockerBackend) NetworkRemove(ctx context.Context, id string) error {
+	return b.client.NetworkRemove(ctx, id)
+}
+
+func (b *dockerBackend) VolumeList(ctx context.Context) ([]*types.Volume, error) {
+	res, err := b.client.VolumeList(ctx, filters.NewArgs())
+	return res.Volumes, err
+}
+
+func (b *dockerBackend) VolumeInspect(ctx context.Context, name string) (*types.Volume, error) {
+	v, err := b.client.VolumeInspect(ctx, name)
+	return &v, err
+}
+
+func (b *dockerBackend) VolumeRemove(ctx context.Context, name string) error {
+	return b.client.VolumeRemove(ctx, name, false)
+}
+
+func (b *dockerBackend) DiskUsage(ctx context.Context) (types.DiskUsage, error) {
+	return b.client.DiskUsage(ctx)
+}
+
+func (b *dockerBackend) Events(ctx context.Context, opts EventOptions) (<-chan events.Message, <-chan error) {
+	args := filters.NewArgs()
+	for _, v := range opts.Types {
+		args.Add("type", v)
+	}
+	for _, v := range opts.Actions {
+		args.Add("event", v)
+	}
+	var since string
+	if !opts.Since.IsZero() {
+		since = fmt.Sprintf("%d.%09d", opts.Since.Unix(), opts.Since.Nanosecond())
+	}
+	return b.client.Events(ctx, types.EventsOptions{
+		Since:   since,
+		Filters: args,
+	})
+}
+
+var containerListArgs = types.ContainerListOptions{
+	All: true,
+}
+
+var containerRemoveOpts = types.ContainerRemoveOptions{
+	RemoveVolumes: true,
+	RemoveLinks:   false,
+	Force:         true,
+}
+
+func imagePruneArgs(until time.Duration) filters.Args {
+	return filters.NewArgs(
+		filters.KeyValuePair{
+			Key:   "until",
+			Value: until.String(),
+		},
+	)
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog/log"
+)
+
+// Policies for resources with a malformed expires or ttl
+// label.
+const (
+	// MalformedWarn logs a warning, and keeps the resource
+	// unless the other expiry label is valid.
+	MalformedWarn = "warn"
+
+	// MalformedIgnore ignores the malformed label, so that
+	// the default time to live applies.
+	MalformedIgnore = "ignore"
+
+	// MalformedExpire removes the resource as if it expired.
+	MalformedExpire = "expire"
+)
+
+// WithMalformedExpiry returns an option to set the policy for
+// resources with a malformed expires or ttl label. Defaults to
+// MalformedWarn.
+func WithMalformedExpiry(policy string) Option {
+	return func(c *collector) {
+		c.malformed = policy
+	}
+}
+
+// expiry explains why a resource is removed, and is recorded
+// in the audit log.
+type expiry struct {
+	policy string
+	detail string
+}
+
+// expired returns true if the resource expired, and the
+// reason it expired. The ttl label and the default time to
+// live are measured from the given time, which is ignored if
+// zero. Labels take precedence over the default time to live.
+func (c *collector) expired(ctx context.Context, name string, labels map[string]string, since time.Time, ttl time.Duration, now time.Time) (bool, expiry) {
+	at, ok, err := parseExpiry(labels, since)
+	if err != nil {
+		logger := log.Ctx(ctx)
+		var errs []error
+		if merr, ok := err.(*multierror.Error); ok {
+			errs = merr.Errors
+		}
+		var details []string
+		for _, err := range errs {
+			details = append(details, err.Error())
+			switch c.malformed {
+			case MalformedExpire:
+				logger.Warn().
+					Err(err).
+					Str("name", name).
+					Msg("malformed expiry label, resource expired")
+			case MalformedIgnore:
+				logger.Debug().
+					Err(err).
+					Str("name", name).
+					Msg("malformed expiry label ignored")
+			default:
+				logger.Warn().
+					Err(err).
+					Str("name", name).
+					Msg("malformed expiry label")
+			}
+		}
+		switch c.malformed {
+		case MalformedExpire:
+			return true, expiry{policy: policyMalformed, detail: strings.Join(details, "; ")}
+		case MalformedIgnore:
+			// the valid label applies, if any, and the
+			// default time to live otherwise.
+		default:
+			if !ok {
+				return false, expiry{}
+			}
+		}
+	}
+	if !ok {
+		if ttl <= 0 || since.Unix() <= 0 {
+			return false, expiry{}
+		}
+		at = since.Add(ttl)
+	}
+	return now.After(at), expiry{policy: policyExpired}
+}
+
+// parseExpiry returns the expiry time set by the valid labels,
+// and false if the labels do not set a valid expiry. The
+// expires label is a unix or RFC3339 timestamp, and the ttl
+// label a duration since the given time. If both are set, the
+// earliest applies. Each malformed label is reported in the
+// returned error.
+func parseExpiry(labels map[string]string, since time.Time) (time.Time, bool, error) {
+	var at time.Time
+	var ok bool
+	var result error
+	if v, exists := labels["io.drone.expires"]; exists {
+		t, err := parseTimestamp(v)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("invalid io.drone.expires label %q", v))
+		} else {
+			at, ok = t, true
+		}
+	}
+	if v, exists := labels["io.drone.ttl"]; exists {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("invalid io.drone.ttl label %q", v))
+		} else if t := since.Add(d); since.Unix() > 0 && (!ok || t.Before(at)) {
+			at, ok = t, true
+		}
+	}
+	return at, ok, result
+}
+
+// parseTimestamp parses a unix or RFC3339 timestamp.
+func parseTimestamp(s string) (time.Time, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(i, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// hasExpiry returns true if the labels set a valid expiry,
+// which takes precedence over the default time to live. A
+// malformed label does not, since the default time to live
+// applies with the ignore policy.
+func hasExpiry(labels map[string]string) bool {
+	if v, ok := labels["io.drone.expires"]; ok {
+		if _, err := parseTimestamp(v); err == nil {
+			return true
+		}
+	}
+	if v, ok := labels["io.drone.ttl"]; ok {
+		if _, err := time.ParseDuration(v); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-gc/mocks"
+
+	"docker.io/go-docker/api/types"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseExpiry(t *testing.T) {
+	since := time.Unix(1192233600, 0)
+	var tests = []struct {
+		labels map[string]string
+		want   time.Time
+		ok     bool
+		err    bool
+	}{
+		{nil, time.Time{}, false, false},
+		{map[string]string{"io.drone.expires": "1192237200"}, since.Add(time.Hour), true, false},
+		{map[string]string{"io.drone.expires": "2007-10-13T01:00:00Z"}, since.Add(time.Hour), true, false},
+		{map[string]string{"io.drone.expires": "2007-10-13T03:00:00+02:00"}, since.Add(time.Hour), true, false},
+		{map[string]string{"io.drone.ttl": "2h"}, since.Add(2 * time.Hour), true, false},
+		// the earliest expiry applies.
+		{map[string]string{"io.drone.expires": "1192237200", "io.drone.ttl": "2h"}, since.Add(time.Hour), true, false},
+		{map[string]string{"io.drone.expires": "1192237200", "io.drone.ttl": "30m"}, since.Add(30 * time.Minute), true, false},
+		// malformed labels.
+		{map[string]string{"io.drone.expires": "2007-10-13"}, time.Time{}, false, true},
+		{map[string]string{"io.drone.ttl": "2 hours"}, time.Time{}, false, true},
+		// a malformed label does not discard the other, valid label.
+		{map[string]string{"io.drone.expires": "2007-10-13", "io.drone.ttl": "2h"}, since.Add(2 * time.Hour), true, true},
+		{map[string]string{"io.drone.expires": "1192237200", "io.drone.ttl": "2 hours"}, since.Add(time.Hour), true, true},
+	}
+	for _, test := range tests {
+		got, ok, err := parseExpiry(test.labels, since)
+		if (err != nil) != test.err {
+			t.Errorf("Want error %v for labels %v, got %v", test.err, test.labels, err)
+		}
+		if ok != test.ok || !got.Equal(test.want) {
+			t.Errorf("Want expiry %v %v for labels %v, got %v %v", test.want, test.ok, test.labels, got, ok)
+		}
+	}
+
+	// the ttl label is ignored if the time it is measured
+	// from is unknown.
+	if _, ok, _ := parseExpiry(map[string]string{"io.drone.ttl": "2h"}, time.Time{}); ok {
+		t.Errorf("Want ttl label ignored without creation time")
+	}
+}
+
+func TestExpired_Malformed(t *testing.T) {
+	now := time.Unix(1192233600, 0)
+	created := now.Add(-2 * time.Hour)
+	labels := map[string]string{"io.drone.expires": "tomorrow"}
+	tests := []struct {
+		policy string
+		ttl    time.Duration
+		want   bool
+	}{
+		{"", 0, false},
+		{MalformedWarn, 0, false},
+		{MalformedWarn, time.Hour, false},
+		{MalformedIgnore, 0, false},
+		{MalformedIgnore, time.Hour, true}, // default ttl applies
+		{MalformedIgnore, 3 * time.Hour, false},
+		{MalformedExpire, 0, true},
+	}
+	for _, test := range tests {
+		c := New(nil, WithMalformedExpiry(test.policy)).(*collector)
+		got, reason := c.expired(context.Background(), "c3d2a6307f4e", labels, created, test.ttl, now)
+		if got != test.want {
+			t.Errorf("Want expired %v with policy %q and ttl %v, got %v", test.want, test.policy, test.ttl, got)
+		}
+		if test.policy == MalformedExpire && (reason.policy != policyMalformed || reason.detail == "") {
+			t.Errorf("Want malformed policy and parse error recorded, got %+v", reason)
+		}
+	}
+}
+
+// this test verifies that a valid ttl label applies when the
+// expires label on the same resource is malformed.
+func TestExpired_MalformedWithValid(t *testing.T) {
+	now := time.Unix(1192233600, 0)
+	created := now.Add(-2 * time.Hour)
+	labels := map[string]string{"io.drone.expires": "tomorrow", "io.drone.ttl": "1h"}
+	tests := []struct {
+		policy string
+		want   bool
+	}{
+		{MalformedWarn, true},
+		{MalformedIgnore, true},
+		{MalformedExpire, true},
+	}
+	for _, test := range tests {
+		c := New(nil, WithMalformedExpiry(test.policy)).(*collector)
+		got, _ := c.expired(context.Background(), "c3d2a6307f4e", labels, created, 3*time.Hour, now)
+		if got != test.want {
+			t.Errorf("Want expired %v with policy %q, got %v", test.want, test.policy, got)
+		}
+	}
+
+	// the valid ttl label keeps the resource, rather than the
+	// default time to live expiring it.
+	labels["io.drone.ttl"] = "3h"
+	c := New(nil, WithMalformedExpiry(MalformedIgnore)).(*collector)
+	if got, _ := c.expired(context.Background(), "c3d2a6307f4e", labels, created, time.Hour, now); got {
+		t.Errorf("Want valid ttl label to override the default ttl")
+	}
+}
+
+// this test verifies that a container with a malformed expiry
+// label is removed with the expire policy, and that the parse
+// error is recorded in the audit log.
+func TestCollectContainers_Malformed(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockContainers := []types.Container{
+		{
+			ID:      "c3d2a6307f4e",
+			Names:   []string{"bar"},
+			State:   "exited",
+			Labels:  map[string]string{"io.drone.expires": "tomorrow"},
+			Created: 359596800,
+		},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil)
+	client.EXPECT().ContainerRemove(gomock.Any(), mockContainers[0].ID, containerRemoveOpts).Return(nil)
+
+	auditor := new(auditRecorder)
+	c := New(NewDockerBackend(client),
+		WithMalformedExpiry(MalformedExpire),
+		WithAuditLog(auditor),
+	).(*collector)
+	if err := c.collectContainers(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if len(auditor.records) != 1 {
+		t.Fatalf("Want 1 audit record, got %d", len(auditor.records))
+	}
+	if got, want := auditor.records[0].Policy, policyMalformed; got != want {
+		t.Errorf("Want audit policy %s, got %s", want, got)
+	}
+	if auditor.records[0].Detail == "" {
+		t.Errorf("Want parse error recorded in the audit log")
+	}
+}
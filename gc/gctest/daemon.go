@@ -0,0 +1,408 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+// Package gctest provides a simulated Docker daemon for end
+// to end tests of the garbage collector.
+package gctest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+	"github.com/drone/drone-gc/gc/memory"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/container"
+	"docker.io/go-docker/api/types/events"
+)
+
+var (
+	// ErrNotFound is returned when the resource does not exist.
+	ErrNotFound = memory.ErrNotFound
+
+	// ErrConflict is returned when the image is used by a
+	// container, or referenced by multiple tags.
+	ErrConflict = memory.ErrConflict
+
+	// ErrHasChildren is returned when the image is the parent
+	// of another image.
+	ErrHasChildren = errors.New("image has dependent child images")
+)
+
+// Layer is an image layer. Layers with the same digest are
+// shared between images and stored once.
+type Layer struct {
+	Digest string
+	Size   int64
+}
+
+// Image is an image stored by the simulated daemon.
+type Image struct {
+	ID      string
+	Tags    []string
+	Digests []string
+	Parent  string
+	Layers  []Layer // all layers, including parent layers
+	Labels  map[string]string
+	Created time.Time
+}
+
+// Option configures the simulated daemon.
+type Option func(*Daemon)
+
+// WithClock returns an option to set the clock used to
+// timestamp events and to prune dangling images.
+func WithClock(now func() time.Time) Option {
+	return func(d *Daemon) {
+		d.now = now
+	}
+}
+
+// Daemon is a stateful, simulated Docker daemon. Containers,
+// networks, volumes and the event stream are provided by the
+// in-memory backend. Images are built from layers, and the
+// disk usage report reflects the layers shared between images.
+type Daemon struct {
+	*memory.Backend
+
+	mu     sync.Mutex
+	now    func() time.Time
+	images []*Image
+}
+
+var _ gc.Backend = (*Daemon)(nil)
+
+// New returns a new simulated daemon with no resources.
+func New(opt ...Option) *Daemon {
+	d := &Daemon{now: time.Now}
+	for _, o := range opt {
+		o(d)
+	}
+	d.Backend = memory.New(memory.WithClock(d.now))
+	return d
+}
+
+// AddImage adds the image and emits an image pull event for
+// each tag. The image creation time defaults to now.
+func (d *Daemon) AddImage(image Image) {
+	if image.Created.IsZero() {
+		image.Created = d.now()
+	}
+	image.Tags = append([]string{}, image.Tags...)
+	image.Digests = append([]string{}, image.Digests...)
+
+	d.mu.Lock()
+	d.images = append(d.images, &image)
+	d.mu.Unlock()
+
+	for _, tag := range image.Tags {
+		d.Emit(events.Message{
+			Type:   events.ImageEventType,
+			Action: "pull",
+			ID:     tag,
+		})
+	}
+}
+
+// AddContainer adds the container and emits a container
+// create event. The container image id is resolved from the
+// image name if empty.
+func (d *Daemon) AddContainer(c types.Container) {
+	if c.ImageID == "" {
+		d.mu.Lock()
+		if image := d.findImage(c.Image); image != nil {
+			c.ImageID = image.ID
+		}
+		d.mu.Unlock()
+	}
+	d.Backend.AddContainer(c)
+}
+
+// HasImage returns true if the image reference exists.
+func (d *Daemon) HasImage(ref string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.findImage(ref) != nil
+}
+
+// ImageInspect returns the image details.
+func (d *Daemon) ImageInspect(ctx context.Context, ref string) (types.ImageInspect, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	image := d.findImage(ref)
+	if image == nil {
+		return types.ImageInspect{}, ErrNotFound
+	}
+	info := types.ImageInspect{
+		ID:          image.ID,
+		RepoTags:    append([]string{}, image.Tags...),
+		RepoDigests: append([]string{}, image.Digests...),
+		Parent:      image.Parent,
+		Created:     image.Created.UTC().Format(time.RFC3339Nano),
+		Size:        imageSize(image),
+		VirtualSize: imageSize(image),
+		Config:      &container.Config{Labels: image.Labels},
+	}
+	for _, layer := range image.Layers {
+		info.RootFS.Layers = append(info.RootFS.Layers, layer.Digest)
+	}
+	return info, nil
+}
+
+// ImageRemove removes the image reference. Removing a tag of
+// an image with multiple tags only untags the image. The
+// image is deleted when removed by id or by its last tag, and
+// untagged parent images are deleted with the image if the
+// prune children option is set.
+func (d *Daemon) ImageRemove(ctx context.Context, ref string, opts types.ImageRemoveOptions) error {
+	used := d.usedImages(ctx)
+
+	d.mu.Lock()
+	image := d.findImage(ref)
+	if image == nil {
+		d.mu.Unlock()
+		return ErrNotFound
+	}
+
+	var msgs []events.Message
+	untag := func(name string) {
+		msgs = append(msgs, events.Message{
+			Type:   events.ImageEventType,
+			Action: "untag",
+			ID:     image.ID,
+			Actor:  events.Actor{ID: image.ID, Attributes: map[string]string{"name": name}},
+		})
+	}
+
+	byID := ref == image.ID
+	switch {
+	case !byID && contains(image.Digests, ref) && len(image.Tags) != 0,
+		!byID && contains(image.Tags, ref) && len(image.Tags) > 1:
+		image.Tags = without(image.Tags, ref)
+		image.Digests = without(image.Digests, ref)
+		untag(ref)
+	case byID && len(image.Tags) > 1 && !opts.Force:
+		d.mu.Unlock()
+		return ErrConflict
+	case d.hasChildren(image):
+		d.mu.Unlock()
+		return ErrHasChildren
+	case used[image.ID] && !opts.Force:
+		d.mu.Unlock()
+		return ErrConflict
+	case used[image.ID]:
+		// the image is forcibly untagged, but is not
+		// deleted while used by a container.
+		for _, name := range append(image.Tags, image.Digests...) {
+			untag(name)
+		}
+		image.Tags = nil
+		image.Digests = nil
+	default:
+		for _, name := range append(image.Tags, image.Digests...) {
+			untag(name)
+		}
+		msgs = append(msgs, d.deleteImage(image)...)
+		for opts.PruneChildren {
+			parent := d.findImage(image.Parent)
+			if parent == nil || len(parent.Tags) != 0 || used[parent.ID] || d.hasChildren(parent) {
+				break
+			}
+			msgs = append(msgs, d.deleteImage(parent)...)
+			image = parent
+		}
+	}
+	d.mu.Unlock()
+
+	for _, msg := range msgs {
+		d.Emit(msg)
+	}
+	return nil
+}
+
+// ImagesPrune removes untagged images created before the
+// given duration that are not used by a container and do not
+// have child images.
+func (d *Daemon) ImagesPrune(ctx context.Context, until time.Duration) (types.ImagesPruneReport, error) {
+	report := types.ImagesPruneReport{}
+	used := d.usedImages(ctx)
+	cutoff := d.now().Add(-until)
+
+	d.mu.Lock()
+	var pruned []*Image
+	for _, image := range d.images {
+		if len(image.Tags) == 0 && !image.Created.After(cutoff) && !used[image.ID] && !d.hasChildren(image) {
+			pruned = append(pruned, image)
+		}
+	}
+	before := d.layersSize()
+	var msgs []events.Message
+	for _, image := range pruned {
+		msgs = append(msgs, d.deleteImage(image)...)
+		report.ImagesDeleted = append(report.ImagesDeleted, types.ImageDeleteResponseItem{
+			Deleted: image.ID,
+		})
+	}
+	report.SpaceReclaimed = uint64(before - d.layersSize())
+	d.mu.Unlock()
+
+	for _, msg := range msgs {
+		d.Emit(msg)
+	}
+	return report, nil
+}
+
+// DiskUsage returns the disk usage report. The layer size
+// counts layers shared between images once.
+func (d *Daemon) DiskUsage(ctx context.Context) (types.DiskUsage, error) {
+	df, err := d.Backend.DiskUsage(ctx)
+	if err != nil {
+		return df, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	df.Images = nil
+	for _, image := range d.images {
+		summary := &types.ImageSummary{
+			ID:          image.ID,
+			ParentID:    image.Parent,
+			RepoTags:    append([]string{}, image.Tags...),
+			RepoDigests: append([]string{}, image.Digests...),
+			Labels:      image.Labels,
+			Created:     image.Created.Unix(),
+			Size:        imageSize(image),
+			VirtualSize: imageSize(image),
+			SharedSize:  d.sharedSize(image),
+		}
+		for _, c := range df.Containers {
+			if c.ImageID == image.ID {
+				summary.Containers++
+			}
+		}
+		df.Images = append(df.Images, summary)
+	}
+	df.LayersSize = d.layersSize()
+	return df, nil
+}
+
+// usedImages returns the ids of images used by containers.
+func (d *Daemon) usedImages(ctx context.Context) map[string]bool {
+	containers, _ := d.Backend.ContainerList(ctx)
+	used := map[string]bool{}
+	for _, c := range containers {
+		used[c.ImageID] = true
+	}
+	return used
+}
+
+// findImage returns the image by id, tag or digest. The lock
+// must be held.
+func (d *Daemon) findImage(ref string) *Image {
+	if ref == "" {
+		return nil
+	}
+	for _, image := range d.images {
+		if image.ID == ref || contains(image.Tags, ref) || contains(image.Digests, ref) {
+			return image
+		}
+	}
+	return nil
+}
+
+// hasChildren returns true if the image is the parent of
+// another image. The lock must be held.
+func (d *Daemon) hasChildren(image *Image) bool {
+	for _, v := range d.images {
+		if v.Parent == image.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteImage deletes the image and returns the delete event.
+// The lock must be held.
+func (d *Daemon) deleteImage(image *Image) []events.Message {
+	for i, v := range d.images {
+		if v == image {
+			d.images = append(d.images[:i], d.images[i+1:]...)
+			break
+		}
+	}
+	return []events.Message{{
+		Type:   events.ImageEventType,
+		Action: "delete",
+		ID:     image.ID,
+	}}
+}
+
+// layersSize returns the size of all layers, counting shared
+// layers once. The lock must be held.
+func (d *Daemon) layersSize() int64 {
+	var size int64
+	seen := map[string]bool{}
+	for _, image := range d.images {
+		for _, layer := range image.Layers {
+			if !seen[layer.Digest] {
+				seen[layer.Digest] = true
+				size += layer.Size
+			}
+		}
+	}
+	return size
+}
+
+// sharedSize returns the size of the image layers that are
+// shared with other images. The lock must be held.
+func (d *Daemon) sharedSize(image *Image) int64 {
+	var size int64
+	for _, layer := range image.Layers {
+		for _, v := range d.images {
+			if v != image && hasLayer(v, layer.Digest) {
+				size += layer.Size
+				break
+			}
+		}
+	}
+	return size
+}
+
+func imageSize(image *Image) int64 {
+	var size int64
+	for _, layer := range image.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+func hasLayer(image *Image, digest string) bool {
+	for _, layer := range image.Layers {
+		if layer.Digest == digest {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func without(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
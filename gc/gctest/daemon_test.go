@@ -0,0 +1,138 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gctest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+	"github.com/drone/drone-gc/gc/cache"
+
+	"docker.io/go-docker/api/types"
+)
+
+var (
+	alpineLayer = Layer{Digest: "sha256:5d20c808ce19", Size: 50}
+	golangLayer = Layer{Digest: "sha256:0a9de0ab2d35", Size: 200}
+	redisLayer  = Layer{Digest: "sha256:8e4a2c6b0b31", Size: 100}
+	nodeLayer   = Layer{Digest: "sha256:c2c1b6a1e8d4", Size: 150}
+)
+
+func TestDaemon_DiskUsage(t *testing.T) {
+	d := New()
+	d.AddImage(Image{ID: "sha256:a180b24e38ed", Tags: []string{"alpine:3"}, Layers: []Layer{alpineLayer}})
+	d.AddImage(Image{ID: "sha256:481995377a04", Tags: []string{"golang:1"}, Layers: []Layer{alpineLayer, golangLayer}})
+
+	df, _ := d.DiskUsage(context.Background())
+	if got, want := df.LayersSize, int64(250); got != want {
+		t.Errorf("Want shared layers counted once, got layer size %d", got)
+	}
+	if got, want := df.Images[1].Size, int64(250); got != want {
+		t.Errorf("Want image size %d, got %d", want, got)
+	}
+	if got, want := df.Images[1].SharedSize, int64(50); got != want {
+		t.Errorf("Want shared size %d, got %d", want, got)
+	}
+
+	d.ImageRemove(context.Background(), "alpine:3", types.ImageRemoveOptions{})
+	df, _ = d.DiskUsage(context.Background())
+	if got, want := df.LayersSize, int64(250); got != want {
+		t.Errorf("Want shared layer retained, got layer size %d", got)
+	}
+}
+
+func TestDaemon_ImageRemove(t *testing.T) {
+	ctx := context.Background()
+	d := New()
+	d.AddImage(Image{ID: "sha256:a180b24e38ed", Layers: []Layer{alpineLayer}})
+	d.AddImage(Image{ID: "sha256:481995377a04", Tags: []string{"golang:1", "golang:latest"}, Parent: "sha256:a180b24e38ed", Layers: []Layer{alpineLayer, golangLayer}})
+	d.AddContainer(types.Container{ID: "c3d2a6307f4e", Image: "golang:1", State: "exited"})
+
+	if err := d.ImageRemove(ctx, "sha256:a180b24e38ed", types.ImageRemoveOptions{}); err != ErrHasChildren {
+		t.Errorf("Want child image conflict, got %v", err)
+	}
+	if err := d.ImageRemove(ctx, "golang:latest", types.ImageRemoveOptions{}); err != nil {
+		t.Errorf("Want image untagged, got %v", err)
+	}
+	if err := d.ImageRemove(ctx, "golang:1", types.ImageRemoveOptions{}); err != ErrConflict {
+		t.Errorf("Want container conflict, got %v", err)
+	}
+
+	d.ContainerRemove(ctx, "c3d2a6307f4e")
+	if err := d.ImageRemove(ctx, "golang:1", types.ImageRemoveOptions{PruneChildren: true}); err != nil {
+		t.Error(err)
+	}
+	if d.HasImage("sha256:481995377a04") || d.HasImage("sha256:a180b24e38ed") {
+		t.Errorf("Want image and untagged parent deleted")
+	}
+	if df, _ := d.DiskUsage(ctx); df.LayersSize != 0 {
+		t.Errorf("Want layers deleted, got layer size %d", df.LayersSize)
+	}
+}
+
+// TestCollect runs a full collection cycle against the
+// simulated daemon, with image use tracked by the cache
+// listener.
+func TestCollect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	d := New()
+	d.AddImage(Image{ID: "sha256:a180b24e38ed", Tags: []string{"alpine:3"}, Layers: []Layer{alpineLayer}, Created: now.Add(-4 * time.Hour)})
+	d.AddImage(Image{ID: "sha256:481995377a04", Tags: []string{"golang:1"}, Layers: []Layer{alpineLayer, golangLayer}, Created: now.Add(-3 * time.Hour)})
+	d.AddImage(Image{ID: "sha256:e3d0f1751532", Tags: []string{"redis:5"}, Layers: []Layer{redisLayer}, Created: now.Add(-2 * time.Hour)})
+	d.AddImage(Image{ID: "sha256:bfbf8512f21e", Tags: []string{"node:10"}, Layers: []Layer{nodeLayer}, Created: now.Add(-time.Hour)})
+
+	backend := cache.Wrap(ctx, d)
+	waitFor(t, func() bool { return d.Listeners() != 0 })
+
+	// the oldest image is used by a pipeline container,
+	// which has since expired.
+	d.AddContainer(types.Container{
+		ID:     "2b8fd9751c4c",
+		Names:  []string{"/drone_1a2b3c"},
+		Image:  "alpine:3",
+		State:  "exited",
+		Labels: map[string]string{"io.drone.expires": "915148800"},
+	})
+	waitFor(t, func() bool {
+		df, _ := backend.DiskUsage(ctx)
+		return df.Images[len(df.Images)-1].ID == "sha256:a180b24e38ed"
+	})
+
+	c := gc.New(backend, gc.WithThreshold(300))
+	if err := c.Collect(ctx); err != nil {
+		t.Error(err)
+	}
+
+	if d.HasImage("golang:1") {
+		t.Errorf("Want least recently used image removed")
+	}
+	for _, image := range []string{"alpine:3", "redis:5", "node:10"} {
+		if !d.HasImage(image) {
+			t.Errorf("Want image %s retained", image)
+		}
+	}
+	if containers, _ := d.ContainerList(ctx); len(containers) != 0 {
+		t.Errorf("Want expired container removed")
+	}
+	if df, _ := d.DiskUsage(ctx); df.LayersSize != 300 {
+		t.Errorf("Want layer size 300, got %d", df.LayersSize)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -6,10 +6,13 @@ package gc
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/drone/drone-gc/gc/audit"
+
 	"docker.io/go-docker/api/types"
-	"docker.io/go-docker/api/types/filters"
 	"github.com/docker/go-units"
 	"github.com/hashicorp/go-multierror"
 	"github.com/rs/zerolog/log"
@@ -20,7 +23,7 @@ func (c *collector) collectDanglingImages(ctx context.Context) error {
 	logger.Debug().
 		Msg("prune dangling images")
 
-	report, err := c.client.ImagesPrune(ctx, imagePruneArgs)
+	report, err := c.client.ImagesPrune(ctx, danglingImageAge)
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -30,12 +33,40 @@ func (c *collector) collectDanglingImages(ctx context.Context) error {
 	logger.Debug().
 		Msg("images pruned")
 
+	var deleted int
 	for _, image := range report.ImagesDeleted {
+		if image.Deleted != "" {
+			deleted++
+		}
+		record := audit.Record{
+			Action: "prune",
+			Kind:   "image",
+			ID:     image.Deleted,
+			Policy: policyDangling,
+		}
+		if image.Untagged != "" {
+			record.Action = "untag"
+			record.ID = image.Untagged
+		}
+		c.audit(ctx, record, nil)
+
 		logger.Info().
 			Str("untagged", image.Untagged).
 			Str("deleted", image.Deleted).
 			Msg("deleted image")
 	}
+
+	// the prune report does not include the size of each
+	// image, so the reclaimed space is recorded in a summary.
+	if deleted != 0 {
+		c.audit(ctx, audit.Record{
+			Action: "prune",
+			Kind:   "image",
+			Size:   int64(report.SpaceReclaimed),
+			Policy: policyDangling,
+			Detail: fmt.Sprintf("%d dangling images pruned", deleted),
+		}, nil)
+	}
 	return nil
 }
 
@@ -50,89 +81,457 @@ func (c *collector) collectImages(ctx context.Context) error {
 			Msg("cannot get disk usage")
 		return err
 	}
+	total := len(df.Images)
+
+	now := c.now()
+	inspected := new(inspections)
+
+	// expired images are removed regardless of the threshold,
+	// and are excluded from the eviction candidates.
+	expired, err := c.expiredImages(ctx, df, inspected, now)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	df.Images = withoutImages(df.Images, expired)
 	size := df.LayersSize
+	for _, e := range expired {
+		size -= e.size
+	}
+
+	evict := size >= c.threshold
+	if evict {
+		df.Images = c.prioritize(ctx, df)
+	}
+
+	// the images approved by the breaker. Eviction stops at
+	// the first candidate outside the plan, since the plan
+	// assumes every removal succeeds.
+	var approved map[string]bool
+	if c.breaker != nil {
+		var plan []types.ImageInspect
+		for _, e := range expired {
+			plan = append(plan, e.info)
+		}
+		approved = map[string]bool{}
+		if evict {
+			evicted, err := c.planImages(ctx, df, size, inspected, now)
+			if err != nil {
+				result = multierror.Append(result, err)
+			}
+			for _, info := range evicted {
+				approved[info.ID] = true
+			}
+			plan = append(plan, evicted...)
+		}
+		if !c.breaker.allow(ctx, plan, total) {
+			return multierror.Append(result, ErrBreakerOpen)
+		}
+	}
 
-	if size < c.threshold {
+	freed, err := c.removeExpired(ctx, expired)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if !evict {
 		logger.Debug().
 			Str("size", units.HumanSize(
-				float64(df.LayersSize),
+				float64(size),
 			)).
 			Str("threshold", units.HumanSize(
 				float64(c.threshold),
 			)).
 			Msg("image cache below threshold")
-		return nil
+		return result
 	}
+	// the bytes of expired images that could not be removed
+	// remain in use.
+	size = df.LayersSize - freed
 
 	logger.Debug().
 		Msg("pruning named images")
 
-	now := time.Now()
-	for _, image := range df.Images {
-		if isImageUsed(image, df.Containers) {
+	// removals run concurrently. The size is reduced once a
+	// removal succeeds, and pending tracks the bytes freed by
+	// removals in flight, so that no more images are removed
+	// than required to reach the threshold.
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		pending int64
+	)
+
+	// images are inspected concurrently ahead of the loop,
+	// which decides on removals in eviction order.
+	candidates := c.prefetch(ctx, df, inspected, now)
+	defer candidates.wait()
+
+	pool := c.newPool()
+	for i, image := range df.Images {
+		if !c.needsEviction(cond, &size, &pending) {
+			break
+		}
+
+		info, ok, err := candidates.get(i)
+		if err != nil {
+			mu.Lock()
+			result = multierror.Append(result, resourceError{err})
+			mu.Unlock()
 			continue
 		}
-		if time.Unix(image.Created, 0).Add(c.minImageAge).After(now) {
+		if !ok {
 			continue
 		}
+		if approved != nil && !approved[info.ID] {
+			logger.Info().
+				Str("id", image.ID).
+				Strs("image", info.RepoTags).
+				Msg("image not in the plan approved by the circuit breaker")
+			break
+		}
+
+		freed := imageFreed(c, image)
+		if !c.reserveImage(freed) {
+			logger.Info().
+				Int("images", c.budget.Images).
+				Int64("bytes", c.budget.Bytes).
+				Msg("image removal budget exhausted")
+			break
+		}
+
+		mu.Lock()
+		pending += freed
+		mu.Unlock()
+
+		image := image
+		started := pool.run(ctx, func() {
+			err := c.removeImageSummary(ctx, image, info, freed, expiry{policy: policyThreshold})
 
-		info, _, err := c.client.ImageInspectWithRaw(ctx, image.ID)
+			mu.Lock()
+			pending -= freed
+			if err != nil {
+				result = multierror.Append(result, resourceError{err})
+				c.releaseImage(freed)
+			} else {
+				size -= freed
+			}
+			cond.Broadcast()
+			mu.Unlock()
+		})
+		if !started {
+			mu.Lock()
+			pending -= freed
+			mu.Unlock()
+			c.releaseImage(freed)
+			break
+		}
+	}
+	pool.wait()
+
+	logger.Debug().
+		Str("size", units.HumanSize(
+			float64(size),
+		)).
+		Msg("done pruning named images")
+
+	return result
+}
+
+// planImages returns the images that would be removed to
+// reduce the size below the threshold, assuming every removal
+// succeeds.
+func (c *collector) planImages(ctx context.Context, df types.DiskUsage, size int64, inspected *inspections, now time.Time) ([]types.ImageInspect, error) {
+	var result error
+	var plan []types.ImageInspect
+
+	candidates := c.prefetch(ctx, df, inspected, now)
+	defer candidates.wait()
+
+	for i, image := range df.Images {
+		if size < c.threshold {
+			break
+		}
+		info, ok, err := candidates.get(i)
 		if err != nil {
-			logger.Error().
-				Err(err).
-				Str("name", image.ID).
-				Msg("cannot find image")
-			result = multierror.Append(result, err)
+			result = multierror.Append(result, resourceError{err})
 			continue
 		}
-
-		if matchPatterns(info.RepoTags, c.reserved) {
+		if !ok {
 			continue
 		}
+		plan = append(plan, info)
+		size -= imageFreed(c, image)
+	}
+	return plan, result
+}
 
-		logger.Debug().
+// inspectCandidate returns the image details and true if
+// the image is a candidate for removal. It is safe to call
+// concurrently.
+func (c *collector) inspectCandidate(ctx context.Context, image *types.ImageSummary, df types.DiskUsage, inspected *inspections, now time.Time) (types.ImageInspect, bool, error) {
+	if isImageUsed(image, df.Containers) {
+		return types.ImageInspect{}, false, nil
+	}
+	if time.Unix(image.Created, 0).Add(c.minImageAge).After(now) {
+		return types.ImageInspect{}, false, nil
+	}
+
+	info, err := inspected.inspect(ctx, c.client, image.ID)
+	if err != nil {
+		return info, false, err
+	}
+
+	if matchPatterns(info.RepoTags, c.reserved, c.registries) {
+		return info, false, nil
+	}
+	if isProtected(imageLabels(info)) {
+		log.Ctx(ctx).Debug().
 			Str("id", image.ID).
-			Str("size", units.HumanSize(
-				float64(image.Size),
-			)).
-			Int64("created", image.Created).
-			Strs("repoTags", info.RepoTags).
-			Strs("repoDigests", info.RepoDigests).
-			Msg("remove image")
+			Strs("image", info.RepoTags).
+			Msg("image is protected")
+		return info, false, nil
+	}
+	return info, true, nil
+}
 
-		err = c.removeImage(ctx, info)
-		if err != nil {
-			logger.Error().
-				Err(err).
+// UsageTracker is implemented by backends that track the use
+// of images.
+type UsageTracker interface {
+	// LastUsed returns the last use of the image, and false
+	// if the last use is unknown.
+	LastUsed(id string) (time.Time, bool)
+
+	// Uses returns the number of recorded uses of the image,
+	// or zero if unknown.
+	Uses(id string) int
+}
+
+// expiredImage is an image that expired by label.
+type expiredImage struct {
+	image  *types.ImageSummary
+	info   types.ImageInspect
+	size   int64
+	reason expiry
+}
+
+// expiredImages returns the images that expired by label,
+// ordered by the unpullable images policy. Candidates are
+// selected by the image summary labels, and the labels of the
+// inspected image are checked. The ttl label is measured from
+// the last use, and ignored if the last use is unknown.
+func (c *collector) expiredImages(ctx context.Context, df types.DiskUsage, inspected *inspections, now time.Time) ([]expiredImage, error) {
+	var result error
+	logger := log.Ctx(ctx)
+
+	var candidates []*types.ImageSummary
+	for _, image := range df.Images {
+		lastUsed := c.lastUsed(image)
+		if at, ok, err := parseExpiry(image.Labels, lastUsed); err == nil && (!ok || !now.After(at)) {
+			continue
+		}
+		if isImageUsed(image, df.Containers) {
+			logger.Debug().
 				Str("id", image.ID).
-				Strs("image", info.RepoTags).
-				Msg("cannot remove image")
-			result = multierror.Append(result, err)
+				Strs("image", image.RepoTags).
+				Msg("expired image is in use")
 			continue
 		}
+		candidates = append(candidates, image)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
 
-		logger.Info().
-			Str("id", image.ID).
-			Strs("image", info.RepoTags).
-			Msg("image removed")
+	// expired images are subject to the unpullable images
+	// policy, like the images evicted to reach the threshold.
+	df.Images = candidates
+	var expired []expiredImage
+	for _, image := range c.prioritize(ctx, df) {
+		info, err := inspected.inspect(ctx, c.client, image.ID)
+		if err != nil {
+			result = multierror.Append(result, resourceError{err})
+			continue
+		}
 
-		size = size - image.Size
-		if shouldConsiderSharedSpace(c) {
-			size -= image.SharedSize
+		labels := imageLabels(info)
+		if isProtected(labels) || matchPatterns(info.RepoTags, c.reserved, c.registries) {
+			continue
 		}
+		ok, reason := c.expired(ctx, image.ID, labels, c.lastUsed(image), 0, now)
+		if !ok {
+			continue
+		}
+		expired = append(expired, expiredImage{
+			image:  image,
+			info:   info,
+			size:   imageFreed(c, image),
+			reason: reason,
+		})
+	}
+	return expired, result
+}
 
-		if size < c.threshold {
+// removeExpired removes the expired images, and returns the
+// number of bytes freed.
+func (c *collector) removeExpired(ctx context.Context, expired []expiredImage) (int64, error) {
+	var (
+		result error
+		mu     sync.Mutex
+		freed  int64
+	)
+	logger := log.Ctx(ctx)
+
+	pool := c.newPool()
+	for _, e := range expired {
+		if !c.reserveImage(e.size) {
+			logger.Info().
+				Int("images", c.budget.Images).
+				Int64("bytes", c.budget.Bytes).
+				Msg("image removal budget exhausted")
+			break
+		}
+
+		e := e
+		started := pool.run(ctx, func() {
+			err := c.removeImageSummary(ctx, e.image, e.info, e.size, e.reason)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result = multierror.Append(result, resourceError{err})
+				c.releaseImage(e.size)
+				return
+			}
+			freed += e.size
+		})
+		if !started {
+			c.releaseImage(e.size)
 			break
 		}
 	}
+	pool.wait()
+	return freed, result
+}
 
+// lastUsed returns the last use of the image, or the zero
+// time if unknown. The image creation time is the build time
+// unless the backend tracks image usage.
+func (c *collector) lastUsed(image *types.ImageSummary) time.Time {
+	tracker, ok := c.client.(UsageTracker)
+	if !ok {
+		return time.Time{}
+	}
+	t, ok := tracker.LastUsed(image.ID)
+	if !ok {
+		return time.Time{}
+	}
+	return t
+}
+
+// uses returns the number of recorded uses of the image, and
+// one if the backend does not track image usage.
+func (c *collector) uses(image *types.ImageSummary) int {
+	tracker, ok := c.client.(UsageTracker)
+	if !ok {
+		return 1
+	}
+	if n := tracker.Uses(image.ID); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// withoutImages returns the images that are not expired.
+func withoutImages(images []*types.ImageSummary, expired []expiredImage) []*types.ImageSummary {
+	if len(expired) == 0 {
+		return images
+	}
+	skip := map[string]bool{}
+	for _, e := range expired {
+		skip[e.image.ID] = true
+	}
+	var result []*types.ImageSummary
+	for _, image := range images {
+		if !skip[image.ID] {
+			result = append(result, image)
+		}
+	}
+	return result
+}
+
+// imageFreed returns the number of bytes freed by removing
+// the image.
+func imageFreed(c *collector, image *types.ImageSummary) int64 {
+	if shouldConsiderSharedSpace(c) {
+		return image.Size + image.SharedSize
+	}
+	return image.Size
+}
+
+// needsEviction returns true if more images must be removed
+// to reach the threshold. If the removals in flight would
+// reach the threshold, it waits for them to complete, since
+// a failed removal does not free any space.
+func (c *collector) needsEviction(cond *sync.Cond, size, pending *int64) bool {
+	cond.L.Lock()
+	defer cond.L.Unlock()
+	for {
+		if *size-*pending >= c.threshold {
+			return true
+		}
+		if *pending == 0 {
+			return false
+		}
+		cond.Wait()
+	}
+}
+
+// removeImageSummary removes the image and logs the number
+// of bytes freed.
+func (c *collector) removeImageSummary(ctx context.Context, image *types.ImageSummary, info types.ImageInspect, freed int64, reason expiry) error {
+	logger := log.Ctx(ctx)
 	logger.Debug().
+		Str("id", image.ID).
 		Str("size", units.HumanSize(
-			float64(size),
+			float64(image.Size),
 		)).
-		Msg("done pruning named images")
+		Int64("created", image.Created).
+		Strs("repoTags", info.RepoTags).
+		Strs("repoDigests", info.RepoDigests).
+		Msg("remove image")
 
-	return result
+	err := c.removeImage(ctx, info)
+	c.audit(ctx, imageRecord(info, freed, reason), err)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Str("id", image.ID).
+			Strs("image", info.RepoTags).
+			Msg("cannot remove image")
+		return err
+	}
+
+	logger.Info().
+		Str("id", image.ID).
+		Strs("image", info.RepoTags).
+		Str("freed", units.HumanSize(
+			float64(freed),
+		)).
+		Msg("image removed")
+	return nil
+}
+
+func imageRecord(info types.ImageInspect, size int64, reason expiry) audit.Record {
+	return audit.Record{
+		Action: "remove",
+		Kind:   "image",
+		ID:     info.ID,
+		Names:  append(append([]string{}, info.RepoTags...), info.RepoDigests...),
+		Size:   size,
+		Policy: reason.policy,
+		Detail: reason.detail,
+		Labels: imageLabels(info),
+	}
 }
 
 func shouldConsiderSharedSpace(c *collector) bool {
@@ -141,29 +540,17 @@ func shouldConsiderSharedSpace(c *collector) bool {
 
 func (c *collector) removeImage(ctx context.Context, imageInspect types.ImageInspect) error {
 	var err error
-	var removalAttributes = []string{imageInspect.ID}
-	if len(imageInspect.RepoTags) > 0 {
-		removalAttributes = imageInspect.RepoTags
-	} else if len(imageInspect.RepoDigests) > 0 {
-		removalAttributes = imageInspect.RepoDigests
-	}
-
-	for _, attribute := range removalAttributes {
-		_, err = c.client.ImageRemove(ctx, attribute, c.imageRemoveOptions)
+	for _, attribute := range imageNames(imageInspect) {
+		err = c.client.ImageRemove(ctx, attribute, c.imageRemoveOptions)
 		if err != nil {
 			break
 		}
 	}
-
 	return err
 }
 
-var imagePruneArgs = filters.NewArgs(
-	filters.KeyValuePair{
-		Key:   "until",
-		Value: "1h",
-	},
-)
+// dangling images are pruned once older than this duration.
+var danglingImageAge = time.Hour
 
 func isImageUsed(image *types.ImageSummary, containers []*types.Container) bool {
 	for _, container := range containers {
@@ -7,13 +7,16 @@ package gc
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/drone/drone-gc/mocks"
 
 	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/container"
 	"github.com/golang/mock/gomock"
+	"github.com/hashicorp/go-multierror"
 )
 
 // This test verifies that images that have repoTags
@@ -67,7 +70,7 @@ func TestCollectImages_ByTag(t *testing.T) {
 	client.EXPECT().ImageRemove(gomock.Any(), mockImages[1].RepoTags[0], types.ImageRemoveOptions{}).Return(nil, nil)
 	// we DO NOT remove image 481995377a04
 
-	c := New(client, WithThreshold(500)).(*collector)
+	c := New(NewDockerBackend(client), WithThreshold(500)).(*collector)
 	err := c.collectImages(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -125,7 +128,7 @@ func TestCollectImages_ByDigest(t *testing.T) {
 	client.EXPECT().ImageRemove(gomock.Any(), mockImages[1].RepoDigests[0], types.ImageRemoveOptions{}).Return(nil, nil)
 	// we DO NOT remove image 481995377a04
 
-	c := New(client, WithThreshold(500)).(*collector)
+	c := New(NewDockerBackend(client), WithThreshold(500)).(*collector)
 	err := c.collectImages(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -180,7 +183,7 @@ func TestCollectImages_ById(t *testing.T) {
 	client.EXPECT().ImageRemove(gomock.Any(), mockImages[1].ID, types.ImageRemoveOptions{}).Return(nil, nil)
 	// we DO NOT remove image 481995377a04
 
-	c := New(client, WithThreshold(500)).(*collector)
+	c := New(NewDockerBackend(client), WithThreshold(500)).(*collector)
 	err := c.collectImages(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -221,7 +224,7 @@ func TestCollectImages_MutliError(t *testing.T) {
 	client.EXPECT().ImageRemove(gomock.Any(), mockImages[0].ID, types.ImageRemoveOptions{}).Return(nil, mockError)
 	client.EXPECT().ImageRemove(gomock.Any(), mockImages[1].ID, types.ImageRemoveOptions{}).Return(nil, nil)
 
-	c := New(client).(*collector)
+	c := New(NewDockerBackend(client)).(*collector)
 	err := c.collectImages(context.Background())
 	if err == nil {
 		t.Errorf("Expect multi-error returned")
@@ -240,7 +243,7 @@ func TestCollectImages_BelowThreshold(t *testing.T) {
 	client := mocks.NewMockAPIClient(controller)
 	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
 
-	c := New(client, WithThreshold(2)).(*collector)
+	c := New(NewDockerBackend(client), WithThreshold(2)).(*collector)
 	err := c.collectImages(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -291,13 +294,13 @@ func TestCollectImages_OnlyConsiderSharedSizeWhenPruneChildrenIsTrue(t *testing.
 	client.EXPECT().ImageInspectWithRaw(gomock.Any(), mockImages[1].ID).Return(mockImages[1], nil, nil)
 	client.EXPECT().ImageRemove(gomock.Any(), mockImages[1].RepoTags[0], types.ImageRemoveOptions{}).Return(nil, nil)
 
-	c := New(client, WithThreshold(300)).(*collector)
+	c := New(NewDockerBackend(client), WithThreshold(300)).(*collector)
 	err := c.collectImages(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
 
-	c = New(client, WithThreshold(300), WithImageRemoveOptions(pruneChildrenImageOptions)).(*collector)
+	c = New(NewDockerBackend(client), WithThreshold(300), WithImageRemoveOptions(pruneChildrenImageOptions)).(*collector)
 	err = c.collectImages(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -338,7 +341,7 @@ func TestCollectImages_SkipNotOldEnough(t *testing.T) {
 	client.EXPECT().ImageRemove(gomock.Any(), mockImages[1].ID, types.ImageRemoveOptions{}).Return(nil, nil)
 
 	// Minimum image age set to 30 mins
-	c := New(client, WithMinImageAge(time.Hour/2)).(*collector)
+	c := New(NewDockerBackend(client), WithMinImageAge(time.Hour/2)).(*collector)
 	err := c.collectImages(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -372,7 +375,7 @@ func TestCollectImages_Skip(t *testing.T) {
 	client := mocks.NewMockAPIClient(controller)
 	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
 
-	c := New(client, WithMinImageAge(time.Hour)).(*collector)
+	c := New(NewDockerBackend(client), WithMinImageAge(time.Hour)).(*collector)
 	err := c.collectImages(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -409,7 +412,7 @@ func TestCollectImages_SkipWhitelist(t *testing.T) {
 	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
 	client.EXPECT().ImageInspectWithRaw(gomock.Any(), mockImageInspect.ID).Return(mockImageInspect, nil, nil)
 
-	c := New(client,
+	c := New(NewDockerBackend(client),
 		WithImageWhitelist(
 			[]string{"drone/drone:*"},
 		),
@@ -419,3 +422,280 @@ func TestCollectImages_SkipWhitelist(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// this test verifies that images with an expires or ttl label
+// are removed when expired, even when the image cache is below
+// the target threshold, and that protected and used images are
+// not removed.
+func TestCollectImages_Expired(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	now := time.Unix(1192233600, 0)
+	expired := map[string]string{"io.drone.expires": "359596800"}
+	mockdf := types.DiskUsage{
+		LayersSize: 1000,
+		Images: []*types.ImageSummary{
+			{ID: "a180b24e38ed", Size: 300, Created: now.Add(-3 * time.Hour).Unix(), Labels: expired},
+			{ID: "4e38e38c8ce0", Size: 300, Created: now.Add(-3 * time.Hour).Unix(), Labels: map[string]string{"io.drone.ttl": "2h"}},
+			{ID: "481995377a04", Size: 300, Created: now.Add(-time.Hour).Unix(), Labels: map[string]string{"io.drone.ttl": "2h"}},
+			// the ttl label is ignored if the last use is unknown
+			{ID: "c3d2a6307f4e", Size: 300, Created: now.Add(-3 * time.Hour).Unix(), Labels: map[string]string{"io.drone.ttl": "2h"}},
+			{ID: "6d8c4adbca87", Size: 100, Created: now.Unix(), Labels: expired},
+			{ID: "0f5a2ae4b25f", Size: 100, Created: now.Unix(), Labels: expired},
+		},
+		Containers: []*types.Container{
+			{ImageID: "0f5a2ae4b25f"},
+		},
+	}
+	mockImages := []types.ImageInspect{
+		{ID: "a180b24e38ed", RepoTags: []string{"alpine:latest"}, Config: &container.Config{Labels: expired}},
+		{ID: "4e38e38c8ce0", RepoTags: []string{"busybox:latest"}, Config: &container.Config{Labels: map[string]string{"io.drone.ttl": "2h"}}},
+		{ID: "6d8c4adbca87", RepoTags: []string{"redis:latest"}, Config: &container.Config{Labels: map[string]string{"io.drone.expires": "359596800", "io.drone.protected": "true"}}},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
+	for _, image := range mockImages {
+		client.EXPECT().ImageInspectWithRaw(gomock.Any(), image.ID).Return(image, nil, nil)
+	}
+	client.EXPECT().ImageRemove(gomock.Any(), "alpine:latest", types.ImageRemoveOptions{}).Return(nil, nil)
+	client.EXPECT().ImageRemove(gomock.Any(), "busybox:latest", types.ImageRemoveOptions{}).Return(nil, nil)
+
+	backend := &usageBackend{
+		Backend: NewDockerBackend(client),
+		used: map[string]time.Time{
+			"4e38e38c8ce0": now.Add(-3 * time.Hour),
+			"481995377a04": now.Add(-time.Hour),
+		},
+	}
+	c := New(backend,
+		WithThreshold(5000),
+		WithClock(func() time.Time { return now }),
+	).(*collector)
+	err := c.collectImages(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// this test verifies that expired images are subject to the
+// unpullable images policy and the circuit breaker.
+func TestCollectImages_ExpiredPolicies(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	expired := map[string]string{"io.drone.expires": "359596800"}
+	mockdf := types.DiskUsage{
+		LayersSize: 1000,
+		Images: []*types.ImageSummary{
+			{ID: "a180b24e38ed", Size: 300, Created: 359596800, Labels: expired, RepoDigests: []string{"alpine@sha256:a180b24e38ed"}},
+			{ID: "4e38e38c8ce0", Size: 300, Created: 359596800, Labels: expired},
+			{ID: "481995377a04", Size: 300, Created: 359596800},
+		},
+	}
+	mockImage := types.ImageInspect{ID: "a180b24e38ed", RepoTags: []string{"alpine:latest"}, Config: &container.Config{Labels: expired}}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil).Times(2)
+	client.EXPECT().ImageInspectWithRaw(gomock.Any(), mockImage.ID).Return(mockImage, nil, nil).Times(2)
+	client.EXPECT().ImageRemove(gomock.Any(), "alpine:latest", types.ImageRemoveOptions{}).Return(nil, nil)
+
+	// the breaker trips since the unpullable image is
+	// protected, and the pullable image is a third of the
+	// local images.
+	breaker := NewBreaker(25)
+	c := New(NewDockerBackend(client),
+		WithThreshold(5000),
+		WithUnpullableImages(UnpullableProtect),
+		WithCircuitBreaker(breaker),
+	).(*collector)
+	err := c.collectImages(context.Background())
+	if merr, ok := err.(*multierror.Error); !ok || merr.Errors[0] != ErrBreakerOpen {
+		t.Errorf("Want breaker open error, got %v", err)
+	}
+
+	breaker.Override()
+	if err = c.collectImages(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+// usageBackend is a backend that tracks the last use of
+// images.
+type usageBackend struct {
+	Backend
+	used map[string]time.Time
+	hits map[string]int
+}
+
+func (b *usageBackend) LastUsed(id string) (time.Time, bool) {
+	t, ok := b.used[id]
+	return t, ok
+}
+
+func (b *usageBackend) Uses(id string) int {
+	return b.hits[id]
+}
+
+// this test verifies that images with the protected label are
+// not removed to reach the target threshold.
+func TestCollectImages_Protected(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockdf := types.DiskUsage{
+		LayersSize: 600,
+		Images: []*types.ImageSummary{
+			{ID: "a180b24e38ed", Size: 300, Created: 359596800},
+			{ID: "4e38e38c8ce0", Size: 300, Created: 359596800},
+		},
+	}
+	mockImages := []types.ImageInspect{
+		{ID: "a180b24e38ed", RepoTags: []string{"alpine:latest"}, Config: &container.Config{Labels: map[string]string{"io.drone.protected": "true"}}},
+		{ID: "4e38e38c8ce0", RepoTags: []string{"busybox:latest"}},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
+	for _, image := range mockImages {
+		client.EXPECT().ImageInspectWithRaw(gomock.Any(), image.ID).Return(image, nil, nil)
+	}
+	client.EXPECT().ImageRemove(gomock.Any(), "busybox:latest", types.ImageRemoveOptions{}).Return(nil, nil)
+
+	c := New(NewDockerBackend(client), WithThreshold(500)).(*collector)
+	err := c.collectImages(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// this test verifies that concurrent removals do not remove
+// more images than required to reach the target threshold,
+// and that a failed removal is replaced by the next image.
+func TestCollectImages_Concurrent(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockdf := types.DiskUsage{
+		LayersSize: 1200,
+		Images: []*types.ImageSummary{
+			{ID: "a180b24e38ed", Created: 359596800, Size: 300},
+			{ID: "4e38e38c8ce0", Created: 359596800, Size: 300},
+			{ID: "481995377a04", Created: 359596800, Size: 300},
+			{ID: "6d8c4adbca87", Created: 359596800, Size: 300},
+			// this image should not be removed since removal
+			// of three images will put us below the threshold.
+			{ID: "c3d2a6307f4e", Created: 359596800, Size: 300},
+		},
+	}
+	mockImages := []types.ImageInspect{
+		{ID: "a180b24e38ed"},
+		{ID: "4e38e38c8ce0"},
+		{ID: "481995377a04"},
+		{ID: "6d8c4adbca87"},
+	}
+	mockErr := errors.New("cannot remove image")
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
+	for _, image := range mockImages {
+		client.EXPECT().ImageInspectWithRaw(gomock.Any(), image.ID).Return(image, nil, nil)
+	}
+	// the last image may be inspected ahead of the removals.
+	client.EXPECT().ImageInspectWithRaw(gomock.Any(), "c3d2a6307f4e").Return(types.ImageInspect{ID: "c3d2a6307f4e"}, nil, nil).AnyTimes()
+	client.EXPECT().ImageRemove(gomock.Any(), mockImages[0].ID, types.ImageRemoveOptions{}).Return(nil, nil)
+	client.EXPECT().ImageRemove(gomock.Any(), mockImages[1].ID, types.ImageRemoveOptions{}).Return(nil, mockErr)
+	client.EXPECT().ImageRemove(gomock.Any(), mockImages[2].ID, types.ImageRemoveOptions{}).Return(nil, nil)
+	client.EXPECT().ImageRemove(gomock.Any(), mockImages[3].ID, types.ImageRemoveOptions{}).Return(nil, nil)
+
+	c := New(NewDockerBackend(client), WithThreshold(500), WithConcurrency(4)).(*collector)
+	err := c.collectImages(context.Background())
+	if err == nil {
+		t.Errorf("Expect multi-error returned")
+	}
+}
+
+type slowInspect struct {
+	Backend
+	sync.Mutex
+	inflight, max int
+}
+
+func (b *slowInspect) ImageInspect(ctx context.Context, image string) (types.ImageInspect, error) {
+	b.Lock()
+	b.inflight++
+	if b.inflight > b.max {
+		b.max = b.inflight
+	}
+	b.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	b.Lock()
+	b.inflight--
+	b.Unlock()
+	return types.ImageInspect{ID: image}, nil
+}
+
+// this test verifies that images are inspected concurrently,
+// ahead of the removals, and no more than the concurrency
+// ahead of the threshold.
+func TestCollectImages_ConcurrentInspect(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockdf := types.DiskUsage{LayersSize: 1200}
+	for _, id := range []string{"a180b24e38ed", "4e38e38c8ce0", "481995377a04", "6d8c4adbca87", "c3d2a6307f4e", "0f5a2ae4b25f", "bfbf8512f21e", "7c5f0c5d8a3b"} {
+		mockdf.Images = append(mockdf.Images, &types.ImageSummary{ID: id, Created: 359596800, Size: 100})
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
+	client.EXPECT().ImageRemove(gomock.Any(), gomock.Any(), types.ImageRemoveOptions{}).Return(nil, nil).Times(2)
+
+	backend := &slowInspect{Backend: NewDockerBackend(client)}
+	c := New(backend, WithThreshold(1050), WithConcurrency(4)).(*collector)
+	if err := c.collectImages(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if backend.max < 2 {
+		t.Errorf("Want concurrent inspections, got %d in flight", backend.max)
+	}
+	if backend.max > 4 {
+		t.Errorf("Want at most 4 inspections in flight, got %d", backend.max)
+	}
+}
+
+// this test verifies that the space reclaimed by pruning
+// dangling images is recorded in the audit log.
+func TestCollectDanglingImages_Audit(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	report := types.ImagesPruneReport{
+		ImagesDeleted: []types.ImageDeleteResponseItem{
+			{Untagged: "alpine@sha256:3f1a7b4e0f35"},
+			{Deleted: "sha256:a180b24e38ed"},
+			{Deleted: "sha256:4e38e38c8ce0"},
+		},
+		SpaceReclaimed: 600,
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().ImagesPrune(gomock.Any(), gomock.Any()).Return(report, nil)
+
+	auditor := new(auditRecorder)
+	c := New(NewDockerBackend(client), WithAuditLog(auditor)).(*collector)
+	if err := c.collectDanglingImages(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if got, want := len(auditor.records), 4; got != want {
+		t.Fatalf("Want %d audit records, got %d", want, got)
+	}
+	summary := auditor.records[3]
+	if got, want := summary.Size, int64(600); got != want {
+		t.Errorf("Want %d bytes reclaimed, got %d", want, got)
+	}
+	if got, want := summary.Detail, "2 dangling images pruned"; got != want {
+		t.Errorf("Want detail %q, got %q", want, got)
+	}
+}
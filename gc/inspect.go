@@ -0,0 +1,111 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"docker.io/go-docker/api/types"
+	"github.com/rs/zerolog/log"
+)
+
+// inspections caches the image details for the collection
+// cycle. It is safe for concurrent use.
+type inspections struct {
+	sync.Mutex
+	images map[string]types.ImageInspect
+}
+
+// inspect returns the image details, inspecting the image if
+// it is not cached.
+func (i *inspections) inspect(ctx context.Context, client Backend, id string) (types.ImageInspect, error) {
+	i.Lock()
+	info, ok := i.images[id]
+	i.Unlock()
+	if ok {
+		return info, nil
+	}
+	info, err := client.ImageInspect(ctx, id)
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Str("name", id).
+			Msg("cannot find image")
+		return info, err
+	}
+	i.Lock()
+	if i.images == nil {
+		i.images = map[string]types.ImageInspect{}
+	}
+	i.images[id] = info
+	i.Unlock()
+	return info, nil
+}
+
+// prefetcher inspects removal candidates concurrently, ahead
+// of the caller, which consumes the results in order. No more
+// than the collector concurrency inspections run ahead of the
+// caller, so that images beyond those required to reach the
+// threshold are mostly not inspected.
+type prefetcher struct {
+	ctx       context.Context
+	c         *collector
+	df        types.DiskUsage
+	inspected *inspections
+	now       time.Time
+
+	wg      sync.WaitGroup
+	ahead   int
+	started int
+	results []chan candidate
+}
+
+type candidate struct {
+	info types.ImageInspect
+	ok   bool
+	err  error
+}
+
+func (c *collector) prefetch(ctx context.Context, df types.DiskUsage, inspected *inspections, now time.Time) *prefetcher {
+	ahead := c.concurrency
+	if ahead < 1 {
+		ahead = 1
+	}
+	return &prefetcher{
+		ctx:       ctx,
+		c:         c,
+		df:        df,
+		inspected: inspected,
+		now:       now,
+		ahead:     ahead,
+		results:   make([]chan candidate, len(df.Images)),
+	}
+}
+
+// get returns the image details of the image at index i, and
+// true if the image is a candidate for removal.
+func (p *prefetcher) get(i int) (types.ImageInspect, bool, error) {
+	for ; p.started < len(p.results) && p.started < i+p.ahead; p.started++ {
+		result := make(chan candidate, 1)
+		p.results[p.started] = result
+		image := p.df.Images[p.started]
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			info, ok, err := p.c.inspectCandidate(p.ctx, image, p.df, p.inspected, p.now)
+			result <- candidate{info, ok, err}
+		}()
+	}
+	r := <-p.results[i]
+	return r.info, r.ok, r.err
+}
+
+// wait blocks until the inspections started ahead of the
+// caller are complete.
+func (p *prefetcher) wait() {
+	p.wg.Wait()
+}
@@ -16,3 +16,12 @@ func ExpandImage(name string) string {
 	}
 	return reference.TagNameOnly(ref).String()
 }
+
+// Domain returns the registry host of the image name.
+func Domain(name string) string {
+	ref, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return ""
+	}
+	return reference.Domain(ref)
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"strings"
+)
+
+// Registries maps registry aliases, such as pull-through
+// mirrors, to the canonical registry. An alias is a registry
+// host, optionally followed by a path prefix, for example
+// mirror.internal or mirror.internal/dockerhub.
+type Registries map[string]string
+
+// Expand returns the fully qualified image name, with the
+// registry alias replaced by the canonical registry.
+func (r Registries) Expand(name string) string {
+	full := ExpandImage(name)
+	if len(r) == 0 {
+		return full
+	}
+	return ExpandImage(r.Rewrite(full))
+}
+
+// ExpandPattern returns the fully qualified image name
+// pattern, with the registry alias replaced by the canonical
+// registry. A pattern is not a valid image reference, so the
+// default registry and namespace are added without parsing.
+func (r Registries) ExpandPattern(pattern string) string {
+	pattern = r.Rewrite(pattern)
+	i := strings.Index(pattern, "/")
+	if i != -1 {
+		domain := pattern[:i]
+		if strings.ContainsAny(domain, ".:") || domain == "localhost" {
+			return pattern
+		}
+		return "docker.io/" + pattern
+	}
+	return "docker.io/library/" + pattern
+}
+
+// Rewrite replaces the registry alias prefix of the image name
+// or pattern with the canonical registry. The name is not
+// otherwise normalised.
+func (r Registries) Rewrite(name string) string {
+	var alias string
+	for k := range r {
+		if len(k) > len(alias) && strings.HasPrefix(name, k+"/") {
+			alias = k
+		}
+	}
+	if alias == "" {
+		return name
+	}
+	canonical := strings.TrimSuffix(r[alias], "/")
+	rest := strings.TrimPrefix(name, alias+"/")
+	// official images are in the library namespace of the
+	// default registry.
+	if canonical == "docker.io" && !strings.Contains(rest, "/") {
+		rest = "library/" + rest
+	}
+	return canonical + "/" + rest
+}
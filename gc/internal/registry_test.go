@@ -0,0 +1,74 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package internal
+
+import "testing"
+
+func TestRegistries_Expand(t *testing.T) {
+	registries := Registries{
+		"mirror.internal":            "docker.io",
+		"mirror.internal/quay":       "quay.io",
+		"registry.local:5000/mirror": "docker.io",
+	}
+	testdata := []struct {
+		from string
+		want string
+	}{
+		{
+			from: "golang",
+			want: "docker.io/library/golang:latest",
+		},
+		{
+			from: "mirror.internal/library/golang",
+			want: "docker.io/library/golang:latest",
+		},
+		{
+			from: "mirror.internal/golang:1.12",
+			want: "docker.io/library/golang:1.12",
+		},
+		{
+			from: "mirror.internal/quay/coreos/etcd:v3",
+			want: "quay.io/coreos/etcd:v3",
+		},
+		{
+			from: "registry.local:5000/mirror/drone/drone:1",
+			want: "docker.io/drone/drone:1",
+		},
+		{
+			from: "registry.local:5000/drone/drone:1",
+			want: "registry.local:5000/drone/drone:1",
+		},
+		{
+			from: "mirror.internal/golang@sha256:e4355b66995c96b4b468159fc5c7e3540fcef961189ca13fee877798649f531a",
+			want: "docker.io/library/golang@sha256:e4355b66995c96b4b468159fc5c7e3540fcef961189ca13fee877798649f531a",
+		},
+	}
+	for _, test := range testdata {
+		if got, want := registries.Expand(test.from), test.want; got != want {
+			t.Errorf("Want image %q expanded to %q, got %q", test.from, want, got)
+		}
+	}
+}
+
+func TestRegistries_ExpandPattern(t *testing.T) {
+	registries := Registries{
+		"mirror.internal": "docker.io",
+	}
+	testdata := []struct {
+		from string
+		want string
+	}{
+		{"golang:*", "docker.io/library/golang:*"},
+		{"drone/*", "docker.io/drone/*"},
+		{"mirror.internal/golang:*", "docker.io/library/golang:*"},
+		{"quay.io/coreos/*", "quay.io/coreos/*"},
+		{"localhost/drone:*", "localhost/drone:*"},
+	}
+	for _, test := range testdata {
+		if got, want := registries.ExpandPattern(test.from), test.want; got != want {
+			t.Errorf("Want pattern %q expanded to %q, got %q", test.from, want, got)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+// Package lock provides an exclusive lock backed by a lock
+// file, used to prevent two garbage collectors from
+// collecting the same Docker host.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultTTL is the default duration after which a lock
+// file that is not refreshed is considered stale.
+const DefaultTTL = time.Minute
+
+// ErrLost is returned when the lock file is removed or taken
+// over by another instance while the lock is held.
+var ErrLost = errors.New("lock lost")
+
+// Lock is an exclusive lock backed by a lock file. The lock
+// holder refreshes the file modification time while the lock
+// is held. A lock file that is not refreshed within the ttl is
+// considered stale, and can be taken over by another instance.
+type Lock struct {
+	path  string
+	owner string
+	ttl   time.Duration
+}
+
+// New returns a new lock backed by the lock file at path.
+func New(path string, ttl time.Duration) *Lock {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	hostname, _ := os.Hostname()
+	return &Lock{
+		path:  path,
+		owner: fmt.Sprintf("%s-%d-%x", hostname, os.Getpid(), time.Now().UnixNano()),
+		ttl:   ttl,
+	}
+}
+
+// TryLock attempts to acquire the lock without blocking. It
+// returns false if the lock is held by another instance.
+func (l *Lock) TryLock() (bool, error) {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		var stale bool
+		stale, err = l.removeStale()
+		if err != nil || !stale {
+			return false, err
+		}
+		f, err = os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = f.WriteString(l.owner)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(l.path)
+		return false, err
+	}
+	return true, nil
+}
+
+// Lock blocks until the lock is acquired or the context is
+// cancelled.
+func (l *Lock) Lock(ctx context.Context) error {
+	logger := log.Ctx(ctx)
+	for waiting := false; ; waiting = true {
+		ok, err := l.TryLock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			if waiting {
+				logger.Info().
+					Str("path", l.path).
+					Msg("lock acquired")
+			}
+			return nil
+		}
+		if !waiting {
+			logger.Info().
+				Str("path", l.path).
+				Str("holder", l.holder()).
+				Msg("lock held by another instance, standing by")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.interval()):
+		}
+	}
+}
+
+// Hold refreshes the lock until the context is cancelled. It
+// returns ErrLost if the lock is no longer held.
+func (l *Lock) Hold(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.interval()):
+		}
+		if err := l.Refresh(); err != nil {
+			return err
+		}
+	}
+}
+
+// Refresh updates the lock file modification time. It
+// returns ErrLost if the lock is no longer held.
+func (l *Lock) Refresh() error {
+	if l.holder() != l.owner {
+		return ErrLost
+	}
+	now := time.Now()
+	return os.Chtimes(l.path, now, now)
+}
+
+// Unlock releases the lock. The lock file is only removed if
+// it is still held by this instance.
+func (l *Lock) Unlock() error {
+	if l.holder() != l.owner {
+		return ErrLost
+	}
+	return os.Remove(l.path)
+}
+
+// removeStale removes the lock file if it has not been
+// refreshed within the ttl. The stale file is renamed before
+// it is removed, so that only one instance can take it over.
+func (l *Lock) removeStale() (bool, error) {
+	info, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if time.Since(info.ModTime()) < l.ttl {
+		return false, nil
+	}
+	stale := l.path + "." + l.owner
+	if err := os.Rename(l.path, stale); os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	// the lock may have been taken over and refreshed
+	// between the stat and the rename, in which case it
+	// is restored.
+	info, err = os.Stat(stale)
+	if err == nil && time.Since(info.ModTime()) < l.ttl {
+		return false, os.Rename(stale, l.path)
+	}
+	log.Warn().
+		Str("path", l.path).
+		Msg("removing stale lock")
+	return true, os.Remove(stale)
+}
+
+// holder returns the owner of the lock file.
+func (l *Lock) holder() string {
+	b, _ := ioutil.ReadFile(l.path)
+	return string(b)
+}
+
+func (l *Lock) interval() time.Duration {
+	return l.ttl / 3
+}
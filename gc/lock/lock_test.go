@@ -0,0 +1,110 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package lock
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gc.lock")
+	a := New(path, time.Hour)
+	b := New(path, time.Hour)
+
+	if ok, err := a.TryLock(); err != nil || !ok {
+		t.Fatalf("Want lock acquired, got %v %v", ok, err)
+	}
+	if ok, err := b.TryLock(); err != nil || ok {
+		t.Fatalf("Want lock held by another instance, got %v %v", ok, err)
+	}
+	if err := b.Unlock(); err != ErrLost {
+		t.Errorf("Want ErrLost unlocking a lock not held, got %v", err)
+	}
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryLock(); err != nil || !ok {
+		t.Fatalf("Want lock acquired after release, got %v %v", ok, err)
+	}
+}
+
+func TestLock_Stale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gc.lock")
+	a := New(path, time.Minute)
+	b := New(path, time.Minute)
+
+	if ok, _ := a.TryLock(); !ok {
+		t.Fatalf("Want lock acquired")
+	}
+
+	// simulate an instance that exited without releasing
+	// the lock, and stopped refreshing it.
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(path, past, past)
+
+	if ok, err := b.TryLock(); err != nil || !ok {
+		t.Fatalf("Want stale lock taken over, got %v %v", ok, err)
+	}
+	if err := a.Refresh(); err != ErrLost {
+		t.Errorf("Want ErrLost refreshing a lock taken over, got %v", err)
+	}
+	if err := b.Refresh(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLock_Wait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gc.lock")
+	a := New(path, 300*time.Millisecond)
+	b := New(path, 300*time.Millisecond)
+
+	if ok, _ := a.TryLock(); !ok {
+		t.Fatalf("Want lock acquired")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- b.Lock(context.Background())
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("Want instance standing by while the lock is held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	a.Unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want lock acquired after release")
+	}
+}
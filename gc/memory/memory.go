@@ -0,0 +1,558 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+// Package memory provides an in-memory container runtime
+// backend for tests and simulations.
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/container"
+	"docker.io/go-docker/api/types/events"
+)
+
+var (
+	// ErrNotFound is returned when the resource does not exist.
+	ErrNotFound = errors.New("resource not found")
+
+	// ErrConflict is returned when the image is referenced
+	// by a container, or by multiple tags.
+	ErrConflict = errors.New("resource in use")
+
+	// ErrDisconnected is sent to the event stream subscribers
+	// when the stream is disconnected.
+	ErrDisconnected = errors.New("event stream disconnected")
+)
+
+// maximum number of past events retained for replay.
+const maxHistory = 1000
+
+// Option configures the backend.
+type Option func(*Backend)
+
+// WithClock returns an option to set the clock used to
+// timestamp events and to prune dangling images.
+func WithClock(now func() time.Time) Option {
+	return func(b *Backend) {
+		b.now = now
+	}
+}
+
+// Backend is an in-memory container runtime backend. Removing
+// a resource updates the disk usage report and emits the
+// corresponding runtime event.
+type Backend struct {
+	mu          sync.Mutex
+	now         func() time.Time
+	containers  []*types.Container
+	images      []*types.ImageSummary
+	networks    []*types.NetworkResource
+	volumes     []*types.Volume
+	subscribers []*subscriber
+	history     []events.Message
+}
+
+var _ gc.Backend = (*Backend)(nil)
+
+// New returns a new, empty in-memory backend.
+func New(opt ...Option) *Backend {
+	b := &Backend{now: time.Now}
+	for _, o := range opt {
+		o(b)
+	}
+	return b
+}
+
+// AddImage adds the image and emits an image pull event for
+// each tag.
+func (b *Backend) AddImage(image types.ImageSummary) {
+	b.mu.Lock()
+	b.images = append(b.images, &image)
+	b.mu.Unlock()
+	for _, tag := range image.RepoTags {
+		b.Emit(events.Message{
+			Type:   events.ImageEventType,
+			Action: "pull",
+			ID:     tag,
+		})
+	}
+}
+
+// AddContainer adds the container and emits a container
+// create event.
+func (b *Backend) AddContainer(c types.Container) {
+	b.mu.Lock()
+	if c.ImageID == "" {
+		if image := b.findImage(c.Image); image != nil {
+			c.ImageID = image.ID
+		}
+	}
+	b.containers = append(b.containers, &c)
+	b.mu.Unlock()
+	b.Emit(events.Message{
+		Type:   events.ContainerEventType,
+		Action: "create",
+		ID:     c.ID,
+		From:   c.Image,
+	})
+}
+
+// AddNetwork adds the network.
+func (b *Backend) AddNetwork(network types.NetworkResource) {
+	b.mu.Lock()
+	b.networks = append(b.networks, &network)
+	b.mu.Unlock()
+}
+
+// AddVolume adds the volume.
+func (b *Backend) AddVolume(volume types.Volume) {
+	b.mu.Lock()
+	b.volumes = append(b.volumes, &volume)
+	b.mu.Unlock()
+}
+
+// Emit sends the event to the event stream subscribers. The
+// event time is set if empty.
+func (b *Backend) Emit(msg events.Message) {
+	if msg.Time == 0 {
+		now := b.now()
+		msg.Time = now.Unix()
+		msg.TimeNano = now.UnixNano()
+	}
+	b.mu.Lock()
+	b.history = append(b.history, msg)
+	if len(b.history) > maxHistory {
+		b.history = b.history[len(b.history)-maxHistory:]
+	}
+	subscribers := append([]*subscriber{}, b.subscribers...)
+	b.mu.Unlock()
+	for _, s := range subscribers {
+		s.send(msg)
+	}
+}
+
+// Disconnect closes all event streams with ErrDisconnected.
+func (b *Backend) Disconnect() {
+	b.mu.Lock()
+	subscribers := b.subscribers
+	b.subscribers = nil
+	b.mu.Unlock()
+	for _, s := range subscribers {
+		s.close(ErrDisconnected)
+	}
+}
+
+// Listeners returns the number of event stream subscribers.
+func (b *Backend) Listeners() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// ContainerList returns all containers.
+func (b *Backend) ContainerList(ctx context.Context) ([]types.Container, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var containers []types.Container
+	for _, c := range b.containers {
+		containers = append(containers, *c)
+	}
+	return containers, nil
+}
+
+// ContainerKill stops the container.
+func (b *Backend) ContainerKill(ctx context.Context, id string) error {
+	b.mu.Lock()
+	c := b.findContainer(id)
+	if c == nil {
+		b.mu.Unlock()
+		return ErrNotFound
+	}
+	c.State = "exited"
+	image := c.Image
+	b.mu.Unlock()
+	b.Emit(events.Message{
+		Type:   events.ContainerEventType,
+		Action: "kill",
+		ID:     id,
+		From:   image,
+	})
+	return nil
+}
+
+// ContainerRemove removes the container.
+func (b *Backend) ContainerRemove(ctx context.Context, id string) error {
+	b.mu.Lock()
+	var image string
+	var found bool
+	for i, c := range b.containers {
+		if c.ID == id {
+			image = c.Image
+			b.containers = append(b.containers[:i], b.containers[i+1:]...)
+			found = true
+			break
+		}
+	}
+	b.mu.Unlock()
+	if !found {
+		return ErrNotFound
+	}
+	b.Emit(events.Message{
+		Type:   events.ContainerEventType,
+		Action: "destroy",
+		ID:     id,
+		From:   image,
+	})
+	return nil
+}
+
+// ImageInspect returns the image details.
+func (b *Backend) ImageInspect(ctx context.Context, ref string) (types.ImageInspect, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	image := b.findImage(ref)
+	if image == nil {
+		return types.ImageInspect{}, ErrNotFound
+	}
+	return inspect(image), nil
+}
+
+// ImageRemove removes the image reference. The image is
+// deleted once it is no longer referenced by a tag or digest,
+// or when removed by id.
+func (b *Backend) ImageRemove(ctx context.Context, ref string, opts types.ImageRemoveOptions) error {
+	b.mu.Lock()
+	image := b.findImage(ref)
+	if image == nil {
+		b.mu.Unlock()
+		return ErrNotFound
+	}
+	byID := ref == image.ID
+	if byID && len(image.RepoTags) > 1 && !opts.Force {
+		b.mu.Unlock()
+		return ErrConflict
+	}
+	if b.imageUsed(image) && !opts.Force {
+		b.mu.Unlock()
+		return ErrConflict
+	}
+
+	var msgs []events.Message
+	if !byID {
+		image.RepoTags = without(image.RepoTags, ref)
+		image.RepoDigests = without(image.RepoDigests, ref)
+		msgs = append(msgs, events.Message{
+			Type:   events.ImageEventType,
+			Action: "untag",
+			ID:     image.ID,
+			Actor:  events.Actor{ID: image.ID, Attributes: map[string]string{"name": ref}},
+		})
+	}
+	if byID || (len(image.RepoTags) == 0 && len(image.RepoDigests) == 0 && !b.imageUsed(image)) {
+		b.deleteImage(image)
+		msgs = append(msgs, events.Message{
+			Type:   events.ImageEventType,
+			Action: "delete",
+			ID:     image.ID,
+		})
+	}
+	b.mu.Unlock()
+
+	for _, msg := range msgs {
+		b.Emit(msg)
+	}
+	return nil
+}
+
+// ImagesPrune removes untagged images created before the
+// given duration that are not used by a container.
+func (b *Backend) ImagesPrune(ctx context.Context, until time.Duration) (types.ImagesPruneReport, error) {
+	report := types.ImagesPruneReport{}
+	cutoff := b.now().Add(-until).Unix()
+
+	b.mu.Lock()
+	var pruned []*types.ImageSummary
+	for _, image := range b.images {
+		if len(image.RepoTags) == 0 && image.Created <= cutoff && !b.imageUsed(image) {
+			pruned = append(pruned, image)
+		}
+	}
+	for _, image := range pruned {
+		b.deleteImage(image)
+		report.ImagesDeleted = append(report.ImagesDeleted, types.ImageDeleteResponseItem{
+			Deleted: image.ID,
+		})
+		report.SpaceReclaimed += uint64(image.Size)
+	}
+	b.mu.Unlock()
+
+	for _, image := range pruned {
+		b.Emit(events.Message{
+			Type:   events.ImageEventType,
+			Action: "delete",
+			ID:     image.ID,
+		})
+	}
+	return report, nil
+}
+
+// NetworkList returns all networks.
+func (b *Backend) NetworkList(ctx context.Context) ([]types.NetworkResource, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var networks []types.NetworkResource
+	for _, n := range b.networks {
+		networks = append(networks, *n)
+	}
+	return networks, nil
+}
+
+// NetworkRemove removes the network by id or name.
+func (b *Backend) NetworkRemove(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, n := range b.networks {
+		if n.ID == id || n.Name == id {
+			b.networks = append(b.networks[:i], b.networks[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// VolumeList returns all volumes.
+func (b *Backend) VolumeList(ctx context.Context) ([]*types.Volume, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var volumes []*types.Volume
+	for _, v := range b.volumes {
+		v := *v
+		volumes = append(volumes, &v)
+	}
+	return volumes, nil
+}
+
+// VolumeInspect returns the volume details.
+func (b *Backend) VolumeInspect(ctx context.Context, name string) (*types.Volume, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, v := range b.volumes {
+		if v.Name == name {
+			v := *v
+			return &v, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// VolumeRemove removes the volume.
+func (b *Backend) VolumeRemove(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, v := range b.volumes {
+		if v.Name == name {
+			b.volumes = append(b.volumes[:i], b.volumes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// DiskUsage returns the disk usage report.
+func (b *Backend) DiskUsage(ctx context.Context) (types.DiskUsage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	df := types.DiskUsage{}
+	for _, image := range b.images {
+		summary := *image
+		summary.Containers = 0
+		for _, c := range b.containers {
+			if c.ImageID == image.ID {
+				summary.Containers++
+			}
+		}
+		df.Images = append(df.Images, &summary)
+		df.LayersSize += image.Size
+	}
+	for _, c := range b.containers {
+		c := *c
+		df.Containers = append(df.Containers, &c)
+	}
+	for _, v := range b.volumes {
+		v := *v
+		df.Volumes = append(df.Volumes, &v)
+	}
+	return df, nil
+}
+
+// Events streams the events emitted after the call until the
+// context is cancelled or the stream is disconnected. Past
+// events are replayed first if the since option is set.
+func (b *Backend) Events(ctx context.Context, opts gc.EventOptions) (<-chan events.Message, <-chan error) {
+	b.mu.Lock()
+	var replay []events.Message
+	if !opts.Since.IsZero() {
+		for _, msg := range b.history {
+			if msg.TimeNano >= opts.Since.UnixNano() && matches(opts, msg) {
+				replay = append(replay, msg)
+			}
+		}
+	}
+	s := &subscriber{
+		ctx:    ctx,
+		opts:   opts,
+		eventc: make(chan events.Message, len(replay)+100),
+		errc:   make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+	for _, msg := range replay {
+		s.eventc <- msg
+	}
+	b.subscribers = append(b.subscribers, s)
+	b.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+			return
+		}
+		b.mu.Lock()
+		for i, v := range b.subscribers {
+			if v == s {
+				b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+				break
+			}
+		}
+		b.mu.Unlock()
+		s.close(ctx.Err())
+	}()
+	return s.eventc, s.errc
+}
+
+// findContainer returns the container by id or name. The
+// lock must be held.
+func (b *Backend) findContainer(id string) *types.Container {
+	for _, c := range b.containers {
+		if c.ID == id || contains(c.Names, id) || contains(c.Names, "/"+id) {
+			return c
+		}
+	}
+	return nil
+}
+
+// findImage returns the image by id, tag or digest. The lock
+// must be held.
+func (b *Backend) findImage(ref string) *types.ImageSummary {
+	for _, image := range b.images {
+		if image.ID == ref || contains(image.RepoTags, ref) || contains(image.RepoDigests, ref) {
+			return image
+		}
+	}
+	return nil
+}
+
+// imageUsed returns true if a container uses the image. The
+// lock must be held.
+func (b *Backend) imageUsed(image *types.ImageSummary) bool {
+	for _, c := range b.containers {
+		if c.ImageID == image.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteImage removes the image from the backend. The lock
+// must be held.
+func (b *Backend) deleteImage(image *types.ImageSummary) {
+	for i, v := range b.images {
+		if v == image {
+			b.images = append(b.images[:i], b.images[i+1:]...)
+			return
+		}
+	}
+}
+
+// subscriber is an event stream subscriber.
+type subscriber struct {
+	ctx    context.Context
+	opts   gc.EventOptions
+	eventc chan events.Message
+	errc   chan error
+	done   chan struct{}
+	once   sync.Once
+}
+
+// send sends the event if it matches the subscriber filters.
+// It blocks until the event is received, or the subscriber
+// context is cancelled or the stream disconnected.
+func (s *subscriber) send(msg events.Message) {
+	if !matches(s.opts, msg) {
+		return
+	}
+	select {
+	case s.eventc <- msg:
+	case <-s.ctx.Done():
+	case <-s.done:
+	}
+}
+
+// close ends the event stream with the error.
+func (s *subscriber) close(err error) {
+	s.once.Do(func() {
+		close(s.done)
+		s.errc <- err
+	})
+}
+
+// matches returns true if the event matches the filters.
+func matches(opts gc.EventOptions, msg events.Message) bool {
+	if len(opts.Types) != 0 && !contains(opts.Types, msg.Type) {
+		return false
+	}
+	if len(opts.Actions) != 0 && !contains(opts.Actions, msg.Action) {
+		return false
+	}
+	return true
+}
+
+func inspect(image *types.ImageSummary) types.ImageInspect {
+	return types.ImageInspect{
+		ID:          image.ID,
+		RepoTags:    append([]string{}, image.RepoTags...),
+		RepoDigests: append([]string{}, image.RepoDigests...),
+		Parent:      image.ParentID,
+		Created:     time.Unix(image.Created, 0).UTC().Format(time.RFC3339Nano),
+		Size:        image.Size,
+		VirtualSize: image.VirtualSize,
+		Config:      &container.Config{Labels: image.Labels},
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func without(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+
+	"docker.io/go-docker/api/types"
+)
+
+func TestImageRemove(t *testing.T) {
+	ctx := context.Background()
+	b := New()
+	b.AddImage(types.ImageSummary{ID: "sha256:a180b24e38ed", RepoTags: []string{"golang:1", "golang:latest"}, Size: 100})
+	b.AddImage(types.ImageSummary{ID: "sha256:481995377a04", RepoTags: []string{"redis:latest"}, Size: 50})
+	b.AddContainer(types.Container{ID: "c3d2a6307f4e", Image: "redis:latest", State: "running"})
+
+	if err := b.ImageRemove(ctx, "sha256:a180b24e38ed", types.ImageRemoveOptions{}); err != ErrConflict {
+		t.Errorf("Want conflict removing image with multiple tags by id, got %v", err)
+	}
+	if err := b.ImageRemove(ctx, "redis:latest", types.ImageRemoveOptions{}); err != ErrConflict {
+		t.Errorf("Want conflict removing image used by container, got %v", err)
+	}
+	if err := b.ImageRemove(ctx, "golang:1", types.ImageRemoveOptions{}); err != nil {
+		t.Error(err)
+	}
+	if df, _ := b.DiskUsage(ctx); df.LayersSize != 150 {
+		t.Errorf("Want image retained while tagged, got layer size %d", df.LayersSize)
+	}
+	if err := b.ImageRemove(ctx, "golang:latest", types.ImageRemoveOptions{}); err != nil {
+		t.Error(err)
+	}
+	if df, _ := b.DiskUsage(ctx); df.LayersSize != 50 {
+		t.Errorf("Want image deleted with the last tag, got layer size %d", df.LayersSize)
+	}
+	if err := b.ImageRemove(ctx, "golang:latest", types.ImageRemoveOptions{}); err != ErrNotFound {
+		t.Errorf("Want not found, got %v", err)
+	}
+}
+
+func TestImagesPrune(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	b := New(WithClock(func() time.Time { return now }))
+	b.AddImage(types.ImageSummary{ID: "sha256:a180b24e38ed", Created: now.Add(-2 * time.Hour).Unix(), Size: 100})
+	b.AddImage(types.ImageSummary{ID: "sha256:481995377a04", Created: now.Unix(), Size: 50})
+	b.AddImage(types.ImageSummary{ID: "sha256:e3d0f1751532", RepoTags: []string{"golang:1"}, Created: now.Add(-2 * time.Hour).Unix(), Size: 25})
+
+	report, err := b.ImagesPrune(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.ImagesDeleted) != 1 || report.ImagesDeleted[0].Deleted != "sha256:a180b24e38ed" {
+		t.Errorf("Want old dangling image pruned, got %v", report.ImagesDeleted)
+	}
+	if report.SpaceReclaimed != 100 {
+		t.Errorf("Want 100 bytes reclaimed, got %d", report.SpaceReclaimed)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := New()
+	eventc, _ := b.Events(ctx, gc.EventOptions{
+		Types:   []string{"container"},
+		Actions: []string{"create"},
+	})
+	go func() {
+		b.AddImage(types.ImageSummary{ID: "sha256:a180b24e38ed", RepoTags: []string{"golang:1"}})
+		b.AddContainer(types.Container{ID: "c3d2a6307f4e", Image: "golang:1"})
+	}()
+
+	select {
+	case event := <-eventc:
+		if event.Action != "create" || event.From != "golang:1" {
+			t.Errorf("Want container create event, got %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want container create event")
+	}
+}
+
+func TestEvents_Since(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Unix(1192233600, 0)
+	b := New(WithClock(func() time.Time { return now }))
+	b.AddImage(types.ImageSummary{ID: "sha256:a180b24e38ed", RepoTags: []string{"golang:1"}})
+	now = now.Add(time.Minute)
+	b.AddImage(types.ImageSummary{ID: "sha256:481995377a04", RepoTags: []string{"redis:latest"}})
+
+	eventc, _ := b.Events(ctx, gc.EventOptions{Since: now})
+	select {
+	case event := <-eventc:
+		if event.ID != "redis:latest" {
+			t.Errorf("Want the event since the given time replayed, got %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want image pull event replayed")
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	b := New()
+	_, errc := b.Events(context.Background(), gc.EventOptions{})
+	b.Disconnect()
+	select {
+	case err := <-errc:
+		if err != ErrDisconnected {
+			t.Errorf("Want ErrDisconnected, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Want event stream disconnected")
+	}
+	if got := b.Listeners(); got != 0 {
+		t.Errorf("Want no listeners, got %d", got)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	b := New()
+	b.AddImage(types.ImageSummary{ID: "sha256:a180b24e38ed", RepoTags: []string{"golang:1"}, Size: 100})
+	b.AddImage(types.ImageSummary{ID: "sha256:481995377a04", RepoTags: []string{"redis:latest"}, Size: 100})
+	b.AddImage(types.ImageSummary{ID: "sha256:e3d0f1751532", RepoTags: []string{"alpine:3"}, Size: 100})
+	b.AddContainer(types.Container{ID: "c3d2a6307f4e", Image: "redis:latest", State: "running"})
+	b.AddContainer(types.Container{ID: "2b8fd9751c4c", Image: "alpine:3", State: "exited",
+		Labels: map[string]string{"io.drone.expires": "915148800"}})
+	b.AddVolume(types.Volume{Name: "a180b24e38ed", Driver: "local",
+		Labels: map[string]string{"io.drone.expires": "915148800"}})
+
+	c := gc.New(b, gc.WithThreshold(150))
+	if err := c.Collect(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	df, _ := b.DiskUsage(context.Background())
+	if got, want := df.LayersSize, int64(100); got != want {
+		t.Errorf("Want layer size %d, got %d", want, got)
+	}
+	if got, want := len(df.Containers), 1; got != want {
+		t.Errorf("Want %d containers, got %d", want, got)
+	}
+	if got, want := len(df.Volumes), 0; got != want {
+		t.Errorf("Want %d volumes, got %d", want, got)
+	}
+}
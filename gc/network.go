@@ -6,18 +6,21 @@ package gc
 
 import (
 	"context"
+	"sync"
 
-	"docker.io/go-docker/api/types"
+	"github.com/drone/drone-gc/gc/audit"
 
+	"docker.io/go-docker/api/types"
 	"github.com/hashicorp/go-multierror"
 	"github.com/rs/zerolog/log"
 )
 
 func (c *collector) collectNetworks(ctx context.Context) error {
+	var mu sync.Mutex
 	var result error
 
 	logger := log.Ctx(ctx)
-	networks, err := c.client.NetworkList(ctx, types.NetworkListOptions{})
+	networks, err := c.client.NetworkList(ctx)
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -25,36 +28,120 @@ func (c *collector) collectNetworks(ctx context.Context) error {
 		return err
 	}
 
+	// networks with containers attached are excluded from
+	// the default time to live.
+	var attached map[string]bool
+	if c.networkTTL > 0 {
+		attached, err = c.attachedNetworks(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := c.now()
+	pool := c.newPool()
 	for _, v := range networks {
+		if isPredefinedNetwork(v.Name) {
+			continue
+		}
+		if matchPatterns([]string{v.Name}, c.networkWhitelist, nil) {
+			continue
+		}
 		if isProtected(v.Labels) {
 			logger.Debug().
 				Str("name", v.Name).
 				Msg("network is protected")
 			continue
 		}
-		if isExpired(v.Labels) == false {
+		if c.networkTTL > 0 && !hasExpiry(v.Labels) && (attached[v.ID] || attached[v.Name]) {
+			logger.Debug().
+				Str("name", v.Name).
+				Msg("network is in use")
+			continue
+		}
+		expired, reason := c.expired(ctx, v.Name, v.Labels, v.Created, c.networkTTL, now)
+		if expired == false {
 			logger.Debug().
 				Str("name", v.Name).
 				Msg("network not expired")
 			continue
 		}
 
-		logger.Debug().
-			Str("name", v.Name).
-			Msg("remove network")
+		v := v
+		pool.run(ctx, func() {
+			if err := c.removeNetwork(ctx, v, reason); err != nil {
+				mu.Lock()
+				result = multierror.Append(result, resourceError{err})
+				mu.Unlock()
+			}
+		})
+	}
+	pool.wait()
+	return result
+}
 
-		err = c.client.NetworkRemove(ctx, v.Name)
-		if err != nil {
-			logger.Error().
-				Err(err).
-				Msg("cannot remove network")
-			result = multierror.Append(result, err)
+// attachedNetworks returns the ids and names of the networks
+// containers are attached to.
+func (c *collector) attachedNetworks(ctx context.Context) (map[string]bool, error) {
+	containers, err := c.client.ContainerList(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Msg("cannot list containers")
+		return nil, err
+	}
+	attached := map[string]bool{}
+	for _, cc := range containers {
+		if cc.NetworkSettings == nil {
 			continue
 		}
+		for name, endpoint := range cc.NetworkSettings.Networks {
+			attached[name] = true
+			if endpoint != nil && endpoint.NetworkID != "" {
+				attached[endpoint.NetworkID] = true
+			}
+		}
+	}
+	return attached, nil
+}
 
-		logger.Info().
-			Str("name", v.Name).
-			Msg("network removed")
+// isPredefinedNetwork returns true if the network is created
+// by the Docker daemon and cannot be removed.
+func isPredefinedNetwork(name string) bool {
+	switch name {
+	case "bridge", "host", "none":
+		return true
+	default:
+		return false
 	}
-	return result
+}
+
+// removeNetwork removes the network.
+func (c *collector) removeNetwork(ctx context.Context, v types.NetworkResource, reason expiry) error {
+	logger := log.Ctx(ctx)
+	logger.Debug().
+		Str("name", v.Name).
+		Msg("remove network")
+
+	err := c.client.NetworkRemove(ctx, v.Name)
+	c.audit(ctx, audit.Record{
+		Action: "remove",
+		Kind:   "network",
+		ID:     v.ID,
+		Names:  []string{v.Name},
+		Labels: v.Labels,
+		Policy: reason.policy,
+		Detail: reason.detail,
+	}, err)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Msg("cannot remove network")
+		return err
+	}
+
+	logger.Info().
+		Str("name", v.Name).
+		Msg("network removed")
+	return nil
 }
@@ -14,6 +14,7 @@ import (
 	"github.com/drone/drone-gc/mocks"
 
 	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/network"
 	"github.com/golang/mock/gomock"
 )
 
@@ -31,7 +32,57 @@ func TestCollectNetworks(t *testing.T) {
 	client.EXPECT().NetworkList(gomock.Any(), gomock.Any()).Return(mockNetworks, nil)
 	client.EXPECT().NetworkRemove(gomock.Any(), mockNetworks[0].Name).Return(nil)
 
-	c := New(client).(*collector)
+	c := New(NewDockerBackend(client)).(*collector)
+	err := c.collectNetworks(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// this test verifies that the default time to live skips
+// networks with containers attached, even if a malformed
+// expiry label is ignored, and whitelisted networks.
+func TestCollectNetworks_DefaultTTL(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	now := time.Unix(1192233600, 0)
+	old := now.Add(-25 * time.Hour)
+	mockNetworks := []types.NetworkResource{
+		{Name: "a180b24e38ed", Driver: "bridge", Created: old},
+		{Name: "e3d0f1751532", Driver: "bridge", Created: now.Add(-time.Hour)},
+		{Name: "bfbf8512f21e", Driver: "bridge", Created: old, Labels: map[string]string{"io.drone.protected": "true"}},
+		// skip networks with containers attached
+		{ID: "6d8c4adbca87", Name: "drone_default", Driver: "bridge", Created: old},
+		{ID: "481995377a04", Name: "drone_build", Driver: "bridge", Created: old, Labels: map[string]string{"io.drone.expires": "tomorrow"}},
+		// skip whitelisted networks
+		{Name: "ci_cache", Driver: "bridge", Created: old},
+		{Name: "bridge", Driver: "bridge", Created: old},
+		{Name: "host", Driver: "host", Created: old},
+	}
+	mockContainers := []types.Container{
+		{
+			ID: "c3d2a6307f4e",
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					"drone_default": {NetworkID: "6d8c4adbca87"},
+					"drone_build":   {NetworkID: "481995377a04"},
+				},
+			},
+		},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().NetworkList(gomock.Any(), gomock.Any()).Return(mockNetworks, nil)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil)
+	client.EXPECT().NetworkRemove(gomock.Any(), mockNetworks[0].Name).Return(nil)
+
+	c := New(NewDockerBackend(client),
+		WithNetworkTTL(24*time.Hour),
+		WithNetworkWhitelist([]string{"ci_*"}),
+		WithMalformedExpiry(MalformedIgnore),
+		WithClock(func() time.Time { return now }),
+	).(*collector)
 	err := c.collectNetworks(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -53,7 +104,7 @@ func TestCollectNetworks_MultiError(t *testing.T) {
 	client.EXPECT().NetworkRemove(gomock.Any(), mockNetworks[0].Name).Return(mockErr)
 	client.EXPECT().NetworkRemove(gomock.Any(), mockNetworks[1].Name).Return(nil)
 
-	c := New(client).(*collector)
+	c := New(NewDockerBackend(client)).(*collector)
 	err := c.collectNetworks(context.Background())
 	if err == nil {
 		t.Errorf("Expected multi-error returned")
@@ -7,11 +7,50 @@ package gc
 import (
 	"docker.io/go-docker/api/types"
 	"time"
+
+	"github.com/drone/drone-gc/gc/audit"
+	"github.com/drone/drone-gc/gc/internal"
 )
 
 // Option configures a garbage collector option.
 type Option func(*collector)
 
+// WithAuditLog returns an option to set the audit logger
+// that records every container kill and every resource
+// removal attempted by the collector.
+func WithAuditLog(logger audit.Logger) Option {
+	return func(c *collector) {
+		c.auditor = logger
+	}
+}
+
+// WithClock returns an option to set the clock used to
+// select the policy profile and to evaluate image and build
+// ages, for example when replaying recorded traffic.
+func WithClock(now func() time.Time) Option {
+	return func(c *collector) {
+		c.now = now
+	}
+}
+
+// WithConcurrency returns an option to set the maximum number
+// of concurrent removals in each collection phase. By default,
+// resources are removed one at a time.
+func WithConcurrency(n int) Option {
+	return func(c *collector) {
+		c.concurrency = n
+	}
+}
+
+// WithRateLimit returns an option to limit the number of
+// removal operations per second across all phases. Zero
+// means no limit.
+func WithRateLimit(ops float64) Option {
+	return func(c *collector) {
+		c.limiter = newLimiter(ops)
+	}
+}
+
 // WithDanglingImagesCollection returns an option to set the
 // behaviour the collector should follow when collecting dangling images
 // By default, the collector does not collect them
@@ -21,6 +60,15 @@ func WithDanglingImagesCollection(enabled bool) Option {
 	}
 }
 
+// WithHost returns an option to set the name of the Docker
+// host managed by the collector. The name is included in the
+// audit records.
+func WithHost(host string) Option {
+	return func(c *collector) {
+		c.host = host
+	}
+}
+
 // WithImageRemoveOptions returns an option to set the
 // behaviour the collector should follow when collecting an image
 // The ImageRemoveOptions is the Docker native struct used in the imageRemove function
@@ -49,6 +97,33 @@ func WithWhitelist(names []string) Option {
 	}
 }
 
+// WithNetworkWhitelist returns an option to set a whitelist
+// of network names. This will prevent the garbage collector
+// from removing matching networks.
+func WithNetworkWhitelist(names []string) Option {
+	return func(c *collector) {
+		c.networkWhitelist = append(c.networkWhitelist, names...)
+	}
+}
+
+// WithVolumeWhitelist returns an option to set a whitelist of
+// volume names. This will prevent the garbage collector from
+// removing matching volumes.
+func WithVolumeWhitelist(names []string) Option {
+	return func(c *collector) {
+		c.volumeWhitelist = append(c.volumeWhitelist, names...)
+	}
+}
+
+// WithRegistryAliases returns an option to map registry
+// aliases, such as pull-through mirrors, to the canonical
+// registry when matching image names against patterns.
+func WithRegistryAliases(aliases map[string]string) Option {
+	return func(c *collector) {
+		c.registries = internal.Registries(aliases)
+	}
+}
+
 // WithThreshold returns an option to set a threshold
 // for the image cache. The cache will clear images until
 // the layer size is below the target threshold.
@@ -67,6 +142,36 @@ func WithMinImageAge(minImageAge time.Duration) Option {
 	}
 }
 
+// WithContainerTTL returns an option to set the default time
+// to live of containers without an expiry label, measured from
+// the container creation time. Running containers are excluded.
+// Zero disables the default.
+func WithContainerTTL(ttl time.Duration) Option {
+	return func(c *collector) {
+		c.containerTTL = ttl
+	}
+}
+
+// WithNetworkTTL returns an option to set the default time to
+// live of networks without an expiry label, measured from the
+// network creation time. Networks with containers attached are
+// excluded. Zero disables the default.
+func WithNetworkTTL(ttl time.Duration) Option {
+	return func(c *collector) {
+		c.networkTTL = ttl
+	}
+}
+
+// WithVolumeTTL returns an option to set the default time to
+// live of volumes without an expiry label, measured from the
+// volume creation time. Volumes mounted by a container are not
+// removed. Zero disables the default.
+func WithVolumeTTL(ttl time.Duration) Option {
+	return func(c *collector) {
+		c.volumeTTL = ttl
+	}
+}
+
 // ReservedImages provides a list of reserved images names
 // that should not be removed.
 var ReservedImages = []string{
@@ -0,0 +1,105 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pool executes removals concurrently, bounded by the
+// collector concurrency and rate limits.
+type pool struct {
+	wg      sync.WaitGroup
+	sem     chan struct{}
+	limiter *limiter
+}
+
+func (c *collector) newPool() *pool {
+	concurrency := c.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &pool{
+		sem:     make(chan struct{}, concurrency),
+		limiter: c.limiter,
+	}
+}
+
+// run blocks until a worker is available and the rate limit
+// permits another operation, and then executes fn in the
+// background. It returns false if the context is cancelled
+// before fn is started.
+func (p *pool) run(ctx context.Context, fn func()) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	if err := p.limiter.wait(ctx); err != nil {
+		<-p.sem
+		return false
+	}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+		fn()
+	}()
+	return true
+}
+
+// wait blocks until all operations are complete.
+func (p *pool) wait() {
+	p.wg.Wait()
+}
+
+// limiter limits the rate of operations. A nil limiter
+// does not limit the rate.
+type limiter struct {
+	sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(ops float64) *limiter {
+	if ops <= 0 {
+		return nil
+	}
+	return &limiter{
+		interval: time.Duration(float64(time.Second) / ops),
+	}
+}
+
+// wait blocks until the next operation is permitted.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	now := time.Now()
+	t := l.next
+	if t.Before(now) {
+		t = now
+	}
+	l.next = t.Add(l.interval)
+	l.Unlock()
+
+	if !t.After(now) {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(t.Sub(now)):
+		return nil
+	}
+}
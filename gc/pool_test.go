@@ -0,0 +1,72 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	c := New(nil, WithConcurrency(2)).(*collector)
+	p := c.newPool()
+
+	var mu sync.Mutex
+	var active, peak, done int
+	for i := 0; i < 10; i++ {
+		p.run(context.Background(), func() {
+			mu.Lock()
+			active++
+			if active > peak {
+				peak = active
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			active--
+			done++
+			mu.Unlock()
+		})
+	}
+	p.wait()
+
+	if got, want := done, 10; got != want {
+		t.Errorf("Want %d operations complete, got %d", want, got)
+	}
+	if peak > 2 {
+		t.Errorf("Want at most 2 concurrent operations, got %d", peak)
+	}
+}
+
+func TestPool_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := New(nil, WithRateLimit(0.001)).(*collector)
+	p := c.newPool()
+	p.run(ctx, func() {})
+	if p.run(ctx, func() {}) {
+		t.Errorf("Want operation not started once the context is cancelled")
+	}
+	p.wait()
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Want 5 operations limited to 100 per second, completed in %s", elapsed)
+	}
+	if newLimiter(0) != nil {
+		t.Errorf("Want nil limiter when the rate is unlimited")
+	}
+}
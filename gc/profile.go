@@ -0,0 +1,143 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// DefaultProfile is the name of the profile that applies
+// when no other profile window is active.
+const DefaultProfile = "default"
+
+// Profile defines a named set of collection policies that
+// applies during a time window. A profile replaces the
+// collector threshold, minimum image age, dangling image and
+// time to live settings while its window is active.
+type Profile struct {
+	Name                  string
+	Window                Window
+	Threshold             int64
+	MinImageAge           time.Duration
+	CollectDanglingImages bool
+	ContainerTTL          time.Duration
+	NetworkTTL            time.Duration
+	VolumeTTL             time.Duration
+
+	// Keep is a list of image patterns retained in addition
+	// to the collector image whitelist.
+	Keep []string
+}
+
+// WithProfiles returns an option to set the policy profiles.
+// The first profile with an active window is applied to each
+// collection cycle.
+func WithProfiles(profiles ...Profile) Option {
+	return func(c *collector) {
+		c.profiles = append(c.profiles, profiles...)
+	}
+}
+
+// profile returns a copy of the collector configured with
+// the profile that is active at time t.
+func (c *collector) profile(t time.Time) *collector {
+	cc := *c
+	for _, p := range c.profiles {
+		if !p.Window.Contains(t) {
+			continue
+		}
+		cc.profileName = p.Name
+		cc.threshold = p.Threshold
+		cc.minImageAge = p.MinImageAge
+		cc.shouldCollectDanglingImages = p.CollectDanglingImages
+		cc.containerTTL = p.ContainerTTL
+		cc.networkTTL = p.NetworkTTL
+		cc.volumeTTL = p.VolumeTTL
+		cc.reserved = append(append([]string{}, c.reserved...), p.Keep...)
+		break
+	}
+	return &cc
+}
+
+// ParseProfiles parses a JSON list of profiles. Settings
+// omitted from a profile are inherited from the base profile.
+// Windows without a timezone are evaluated in loc.
+//
+//	[{
+//	  "name": "night",
+//	  "window": "22:00-06:00",
+//	  "cache": "1gb",
+//	  "min_image_age": "10m",
+//	  "collect_dangling_images": true,
+//	  "container_ttl": "1h",
+//	  "network_ttl": "1h",
+//	  "volume_ttl": "24h",
+//	  "keep": ["golang:*"]
+//	}]
+func ParseProfiles(data []byte, base Profile, loc *time.Location) ([]Profile, error) {
+	var in []struct {
+		Name                  string   `json:"name"`
+		Window                string   `json:"window"`
+		Cache                 string   `json:"cache"`
+		MinImageAge           string   `json:"min_image_age"`
+		CollectDanglingImages *bool    `json:"collect_dangling_images"`
+		ContainerTTL          string   `json:"container_ttl"`
+		NetworkTTL            string   `json:"network_ttl"`
+		VolumeTTL             string   `json:"volume_ttl"`
+		Keep                  []string `json:"keep"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, err
+	}
+
+	var profiles []Profile
+	for _, v := range in {
+		p := base
+		p.Name = v.Name
+		p.Keep = v.Keep
+		if p.Name == "" || p.Name == DefaultProfile {
+			return nil, fmt.Errorf("invalid profile name %q", v.Name)
+		}
+
+		var err error
+		if p.Window, err = ParseWindow(v.Window, loc); err != nil {
+			return nil, fmt.Errorf("profile %s: %s", v.Name, err)
+		}
+		if v.Cache != "" {
+			if p.Threshold, err = units.FromHumanSize(v.Cache); err != nil {
+				return nil, fmt.Errorf("profile %s: %s", v.Name, err)
+			}
+		}
+		if v.MinImageAge != "" {
+			if p.MinImageAge, err = time.ParseDuration(v.MinImageAge); err != nil {
+				return nil, fmt.Errorf("profile %s: %s", v.Name, err)
+			}
+		}
+		for _, ttl := range []struct {
+			value string
+			dst   *time.Duration
+		}{
+			{v.ContainerTTL, &p.ContainerTTL},
+			{v.NetworkTTL, &p.NetworkTTL},
+			{v.VolumeTTL, &p.VolumeTTL},
+		} {
+			if ttl.value == "" {
+				continue
+			}
+			if *ttl.dst, err = time.ParseDuration(ttl.value); err != nil {
+				return nil, fmt.Errorf("profile %s: %s", v.Name, err)
+			}
+		}
+		if v.CollectDanglingImages != nil {
+			p.CollectDanglingImages = *v.CollectDanglingImages
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
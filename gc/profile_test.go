@@ -0,0 +1,164 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseProfiles(t *testing.T) {
+	base := Profile{
+		Threshold:    5000,
+		MinImageAge:  time.Hour,
+		ContainerTTL: 3 * time.Hour,
+	}
+	profiles, err := ParseProfiles([]byte(`[
+		{
+			"name": "night",
+			"window": "22:00-06:00",
+			"cache": "1kb",
+			"min_image_age": "10m",
+			"collect_dangling_images": true,
+			"container_ttl": "1h",
+			"network_ttl": "2h",
+			"volume_ttl": "24h",
+			"keep": ["golang:*"]
+		},
+		{
+			"name": "weekend",
+			"window": "Sat,Sun 00:00-24:00"
+		}
+	]`), base, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(profiles), 2; got != want {
+		t.Fatalf("Want %d profiles, got %d", want, got)
+	}
+
+	night := profiles[0]
+	if got, want := night.Threshold, int64(1000); got != want {
+		t.Errorf("Want threshold %d, got %d", want, got)
+	}
+	if got, want := night.MinImageAge, 10*time.Minute; got != want {
+		t.Errorf("Want min image age %s, got %s", want, got)
+	}
+	if !night.CollectDanglingImages {
+		t.Errorf("Want dangling image collection enabled")
+	}
+	if got, want := night.ContainerTTL, time.Hour; got != want {
+		t.Errorf("Want container ttl %s, got %s", want, got)
+	}
+	if got, want := night.NetworkTTL, 2*time.Hour; got != want {
+		t.Errorf("Want network ttl %s, got %s", want, got)
+	}
+	if got, want := night.VolumeTTL, 24*time.Hour; got != want {
+		t.Errorf("Want volume ttl %s, got %s", want, got)
+	}
+	if got, want := night.Keep, []string{"golang:*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want keep %v, got %v", want, got)
+	}
+
+	// settings omitted from the profile are inherited.
+	weekend := profiles[1]
+	if got, want := weekend.Threshold, base.Threshold; got != want {
+		t.Errorf("Want inherited threshold %d, got %d", want, got)
+	}
+	if got, want := weekend.MinImageAge, base.MinImageAge; got != want {
+		t.Errorf("Want inherited min image age %s, got %s", want, got)
+	}
+	if got, want := weekend.ContainerTTL, base.ContainerTTL; got != want {
+		t.Errorf("Want inherited container ttl %s, got %s", want, got)
+	}
+}
+
+func TestParseProfiles_Error(t *testing.T) {
+	for _, data := range []string{
+		`{}`,
+		`[{"window": "22:00-06:00"}]`,
+		`[{"name": "default", "window": "22:00-06:00"}]`,
+		`[{"name": "night"}]`,
+		`[{"name": "night", "window": "22:00-06:00", "cache": "lots"}]`,
+		`[{"name": "night", "window": "22:00-06:00", "min_image_age": "1 day"}]`,
+		`[{"name": "night", "window": "22:00-06:00", "volume_ttl": "1 day"}]`,
+	} {
+		if _, err := ParseProfiles([]byte(data), Profile{}, time.UTC); err == nil {
+			t.Errorf("Want error parsing profiles %s", data)
+		}
+	}
+}
+
+func TestCollector_Profile(t *testing.T) {
+	night := Profile{
+		Name:         "night",
+		Window:       Window{Start: 22 * time.Hour, End: 6 * time.Hour},
+		Threshold:    1,
+		MinImageAge:  time.Minute,
+		ContainerTTL: time.Hour,
+		Keep:         []string{"golang:*"},
+	}
+	c := New(nil,
+		WithThreshold(42),
+		WithContainerTTL(24*time.Hour),
+		WithImageWhitelist([]string{"drone/*"}),
+		WithProfiles(night),
+	).(*collector)
+
+	day := c.profile(time.Date(2019, time.March, 1, 12, 0, 0, 0, time.UTC))
+	if got, want := day.profileName, DefaultProfile; got != want {
+		t.Errorf("Want profile %q, got %q", want, got)
+	}
+	if got, want := day.threshold, int64(42); got != want {
+		t.Errorf("Want threshold %d, got %d", want, got)
+	}
+	if got, want := day.containerTTL, 24*time.Hour; got != want {
+		t.Errorf("Want container ttl %s, got %s", want, got)
+	}
+
+	active := c.profile(time.Date(2019, time.March, 1, 23, 0, 0, 0, time.UTC))
+	if got, want := active.profileName, "night"; got != want {
+		t.Errorf("Want profile %q, got %q", want, got)
+	}
+	if got, want := active.threshold, int64(1); got != want {
+		t.Errorf("Want threshold %d, got %d", want, got)
+	}
+	if got, want := active.containerTTL, time.Hour; got != want {
+		t.Errorf("Want container ttl %s, got %s", want, got)
+	}
+	if got, want := active.reserved, []string{"drone/*", "golang:*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want image whitelist %v, got %v", want, got)
+	}
+	if got, want := c.reserved, []string{"drone/*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Want the base image whitelist unchanged, got %v", got)
+	}
+}
+
+// this test verifies that the threshold reported to the pull
+// trigger is the threshold of the active profile, scaled by
+// the tuner.
+func TestThreshold(t *testing.T) {
+	night := Profile{
+		Name:      "night",
+		Window:    Window{Start: 22 * time.Hour, End: 6 * time.Hour},
+		Threshold: 500,
+	}
+	now := time.Date(2019, time.March, 1, 23, 0, 0, 0, time.UTC)
+	tuner := NewTuner("", 1000, 0, 0, true)
+	c := New(nil,
+		WithThreshold(1000),
+		WithProfiles(night),
+		WithTuner(tuner),
+		WithClock(func() time.Time { return now }),
+	)
+	if got, want := Threshold(c), int64(500); got != want {
+		t.Errorf("Want profile threshold %d, got %d", want, got)
+	}
+	tuner.factor = 1.5
+	if got, want := Threshold(c), int64(750); got != want {
+		t.Errorf("Want tuned profile threshold %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"docker.io/go-docker/api/types"
+	"github.com/rs/zerolog/log"
+)
+
+// Policies for images that cannot be pulled again once
+// removed, such as locally built images.
+const (
+	// UnpullableIgnore evicts images regardless of whether
+	// they can be pulled again.
+	UnpullableIgnore = "ignore"
+
+	// UnpullableProtect never evicts images that cannot be
+	// pulled again.
+	UnpullableProtect = "protect"
+
+	// UnpullableLast evicts images that cannot be pulled
+	// again only after all other candidates.
+	UnpullableLast = "last"
+)
+
+// Registry checks whether image manifests exist in the image
+// registry.
+type Registry interface {
+	// ManifestExists returns true if the manifest of the
+	// image reference, in name@digest or name:tag format,
+	// exists.
+	ManifestExists(ctx context.Context, ref string) (bool, error)
+}
+
+// WithUnpullableImages returns an option to set the policy
+// for images that cannot be pulled again, which are images
+// without a repository digest.
+func WithUnpullableImages(policy string) Option {
+	return func(c *collector) {
+		c.unpullable = policy
+	}
+}
+
+// WithRegistryCheck returns an option to check that the
+// manifest of an image still exists in the registry before
+// eviction. Images whose manifest was deleted cannot be pulled
+// again, and are handled by the unpullable images policy.
+func WithRegistryCheck(registry Registry) Option {
+	return func(c *collector) {
+		c.registry = registry
+	}
+}
+
+// prioritize returns the images in eviction order, weighed by
+// pull cost, and applying the unpullable images policy.
+// Protected images are removed from the list, and other
+// unpullable images are moved to the end, preserving the
+// order.
+func (c *collector) prioritize(ctx context.Context, df types.DiskUsage) []*types.ImageSummary {
+	weighed := c.weigh(df.Images)
+	if c.unpullable == "" || c.unpullable == UnpullableIgnore {
+		return weighed
+	}
+	pullable := c.checkPullable(ctx, weighed, df)
+	var images, last []*types.ImageSummary
+	for i, image := range weighed {
+		if pullable[i] {
+			images = append(images, image)
+			continue
+		}
+		log.Ctx(ctx).Debug().
+			Str("image", image.ID).
+			Strs("tags", image.RepoTags).
+			Str("policy", c.unpullable).
+			Msg("image cannot be pulled again")
+		if c.unpullable == UnpullableLast {
+			last = append(last, image)
+		}
+	}
+	return append(images, last...)
+}
+
+// checkPullable returns whether each image can be pulled
+// again. Used images and images below the minimum age are not
+// evicted, so there is no need to check them. The registry is
+// checked concurrently.
+func (c *collector) checkPullable(ctx context.Context, images []*types.ImageSummary, df types.DiskUsage) []bool {
+	now := c.now()
+	pullable := make([]bool, len(images))
+	pool := c.newPool()
+	for i, image := range images {
+		if isImageUsed(image, df.Containers) || time.Unix(image.Created, 0).Add(c.minImageAge).After(now) {
+			pullable[i] = true
+			continue
+		}
+		if c.registry == nil {
+			pullable[i] = c.pullable(ctx, image)
+			continue
+		}
+		i, image := i, image
+		if !pool.run(ctx, func() {
+			pullable[i] = c.pullable(ctx, image)
+		}) {
+			break
+		}
+	}
+	pool.wait()
+	return pullable
+}
+
+// pullable returns true if the image can be pulled again. An
+// image can be pulled again if it has a repository digest and,
+// if a registry check is configured, the manifest exists by
+// digest and by each of its tags. The image is assumed
+// pullable if the registry cannot be reached.
+func (c *collector) pullable(ctx context.Context, image *types.ImageSummary) bool {
+	var digests []string
+	for _, digest := range image.RepoDigests {
+		if !strings.Contains(digest, "<none>") {
+			digests = append(digests, digest)
+		}
+	}
+	if len(digests) == 0 {
+		return false
+	}
+	if c.registry == nil {
+		return true
+	}
+	var found bool
+	for _, digest := range digests {
+		exists, err := c.registry.ManifestExists(ctx, digest)
+		if err != nil {
+			log.Ctx(ctx).Warn().
+				Err(err).
+				Str("image", digest).
+				Msg("cannot check image manifest")
+			return true
+		}
+		if exists {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	// a tag deleted from the registry cannot be pulled again,
+	// even if the manifest still exists by digest.
+	for _, tag := range image.RepoTags {
+		if strings.Contains(tag, "<none>") {
+			continue
+		}
+		exists, err := c.registry.ManifestExists(ctx, tag)
+		if err != nil {
+			log.Ctx(ctx).Warn().
+				Err(err).
+				Str("image", tag).
+				Msg("cannot check image manifest")
+			continue
+		}
+		if !exists {
+			return false
+		}
+	}
+	return true
+}
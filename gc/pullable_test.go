@@ -0,0 +1,100 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/drone/drone-gc/mocks"
+
+	"docker.io/go-docker/api/types"
+	"github.com/golang/mock/gomock"
+)
+
+type fakeRegistry map[string]bool
+
+func (r fakeRegistry) ManifestExists(ctx context.Context, ref string) (bool, error) {
+	exists, ok := r[ref]
+	if !ok {
+		return false, errors.New("registry unavailable")
+	}
+	return exists, nil
+}
+
+var unpullableDiskUsage = types.DiskUsage{
+	Images: []*types.ImageSummary{
+		{ID: "a180b24e38ed", RepoTags: []string{"local/app:latest"}},
+		{ID: "4e38e38c8ce0", RepoTags: []string{"alpine:latest"}, RepoDigests: []string{"alpine@sha256:4e38"}},
+		{ID: "481995377a04", RepoTags: []string{"golang:1"}, RepoDigests: []string{"golang@sha256:4819"}},
+		{ID: "6d8c4adbca87", RepoTags: []string{"redis:latest"}, RepoDigests: []string{"redis@sha256:6d8c"}},
+		{ID: "0f5a2ae4b25f", RepoTags: []string{"node:10"}, RepoDigests: []string{"node@sha256:0f5a"}},
+	},
+}
+
+func TestPrioritize(t *testing.T) {
+	registry := fakeRegistry{
+		"alpine@sha256:4e38": true,
+		"alpine:latest":      true,
+		"golang@sha256:4819": false, // manifest deleted
+		"node@sha256:0f5a":   true,
+		"node:10":            false, // tag deleted
+	}
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{UnpullableIgnore, []string{"a180b24e38ed", "4e38e38c8ce0", "481995377a04", "6d8c4adbca87", "0f5a2ae4b25f"}},
+		{UnpullableProtect, []string{"4e38e38c8ce0", "6d8c4adbca87"}},
+		{UnpullableLast, []string{"4e38e38c8ce0", "6d8c4adbca87", "a180b24e38ed", "481995377a04", "0f5a2ae4b25f"}},
+	}
+	for _, test := range tests {
+		c := New(nil, WithUnpullableImages(test.policy), WithRegistryCheck(registry)).(*collector)
+		images := c.prioritize(context.Background(), unpullableDiskUsage)
+		var got []string
+		for _, image := range images {
+			got = append(got, image.ID)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("Want images %v with policy %s, got %v", test.want, test.policy, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("Want images %v with policy %s, got %v", test.want, test.policy, got)
+				break
+			}
+		}
+	}
+}
+
+// This test verifies that images without a repository digest
+// are not removed with the protect policy.
+func TestCollectImages_Unpullable(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockdf := types.DiskUsage{
+		LayersSize: 600,
+		Images: []*types.ImageSummary{
+			{ID: "a180b24e38ed", Size: 300, RepoTags: []string{"local/app:latest"}},
+			{ID: "4e38e38c8ce0", Size: 300, RepoTags: []string{"alpine:latest"}, RepoDigests: []string{"alpine@sha256:4e38"}},
+		},
+	}
+	mockImage := types.ImageInspect{ID: "4e38e38c8ce0", RepoTags: []string{"alpine:latest"}}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().DiskUsage(gomock.Any()).Return(mockdf, nil)
+	client.EXPECT().ImageInspectWithRaw(gomock.Any(), mockImage.ID).Return(mockImage, nil, nil)
+	client.EXPECT().ImageRemove(gomock.Any(), "alpine:latest", types.ImageRemoveOptions{}).Return(nil, nil)
+	// we DO NOT remove the locally built image
+
+	c := New(NewDockerBackend(client), WithThreshold(500), WithUnpullableImages(UnpullableProtect)).(*collector)
+	err := c.collectImages(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
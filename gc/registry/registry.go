@@ -0,0 +1,273 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+// Package registry checks whether image manifests exist using
+// the Docker Registry HTTP API V2.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+	"github.com/drone/drone-gc/gc/internal"
+
+	"github.com/docker/distribution/reference"
+)
+
+// DefaultTTL is the default duration a manifest check result
+// is cached.
+const DefaultTTL = time.Hour
+
+// DefaultTimeout is the default timeout of a registry request,
+// including authentication.
+const DefaultTimeout = 10 * time.Second
+
+// manifest media types accepted when checking a manifest.
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v1+prettyjws",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// Option configures the registry client.
+type Option func(*Client)
+
+// WithHTTPClient returns an option to set the http client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithAliases returns an option to set the registry aliases,
+// such as pull-through mirrors, which map to the canonical
+// registry. Images pulled through an alias are checked in the
+// canonical registry, unless the alias itself is checked.
+func WithAliases(aliases map[string]string) Option {
+	return func(c *Client) {
+		c.aliases = aliases
+	}
+}
+
+// WithTTL returns an option to set the duration a manifest
+// check result is cached.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.ttl = ttl
+	}
+}
+
+// Client checks image manifests in the configured registries.
+// Images from other registries are assumed to exist.
+type Client struct {
+	client    *http.Client
+	endpoints map[string]*url.URL // by registry host
+	aliases   internal.Registries
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	results map[string]result
+}
+
+type result struct {
+	exists  bool
+	expires time.Time
+}
+
+var _ gc.Registry = (*Client)(nil)
+
+// New returns a registry client. The endpoints map registry
+// hosts, as they appear in image names, to the registry API
+// address.
+func New(endpoints map[string]string, opt ...Option) (*Client, error) {
+	c := &Client{
+		client:    &http.Client{Timeout: DefaultTimeout},
+		endpoints: map[string]*url.URL{},
+		ttl:       DefaultTTL,
+		results:   map[string]result{},
+	}
+	for host, addr := range endpoints {
+		u, err := url.Parse(addr)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid registry address %q", addr)
+		}
+		c.endpoints[host] = u
+	}
+	for _, o := range opt {
+		o(c)
+	}
+	return c, nil
+}
+
+// ManifestExists returns true if the manifest of the image
+// reference, in name@digest or name:tag format, exists in the
+// registry.
+func (c *Client) ManifestExists(ctx context.Context, ref string) (bool, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return false, err
+	}
+	var manifest string
+	switch v := named.(type) {
+	case reference.Digested:
+		manifest = v.Digest().String()
+	case reference.Tagged:
+		manifest = v.Tag()
+	default:
+		return false, fmt.Errorf("image reference %q has no digest or tag", ref)
+	}
+	endpoint, ok := c.endpoints[reference.Domain(named)]
+	if !ok && len(c.aliases) != 0 {
+		// images pulled through a registry alias are checked
+		// in the canonical registry.
+		canonical, err := reference.ParseNormalizedNamed(c.aliases.Expand(ref))
+		if err == nil {
+			named = canonical
+			endpoint, ok = c.endpoints[reference.Domain(named)]
+		}
+	}
+	if !ok {
+		return true, nil
+	}
+
+	key := named.String()
+	now := time.Now()
+	c.mu.Lock()
+	r, ok := c.results[key]
+	c.mu.Unlock()
+	if ok && now.Before(r.expires) {
+		return r.exists, nil
+	}
+
+	u := *endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v2/" + reference.Path(named) + "/manifests/" + manifest
+	exists, err := c.head(ctx, u.String(), reference.Path(named))
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.results[key] = result{exists: exists, expires: now.Add(c.ttl)}
+	for k, v := range c.results {
+		if now.After(v.expires) {
+			delete(c.results, k)
+		}
+	}
+	c.mu.Unlock()
+	return exists, nil
+}
+
+// head requests the manifest, authenticating with an
+// anonymous bearer token if the registry requires it.
+func (c *Client) head(ctx context.Context, addr, repository string) (bool, error) {
+	res, err := c.do(ctx, addr, "")
+	if err != nil {
+		return false, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		token, err := c.token(ctx, res.Header.Get("Www-Authenticate"), repository)
+		if err != nil {
+			return false, err
+		}
+		res, err = c.do(ctx, addr, token)
+		if err != nil {
+			return false, err
+		}
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("registry responded %s", res.Status)
+	}
+}
+
+func (c *Client) do(ctx context.Context, addr, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+// token requests an anonymous pull token from the realm in
+// the bearer challenge.
+func (c *Client) token(ctx context.Context, challenge, repository string) (string, error) {
+	params := parseChallenge(challenge)
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", "repository:"+repository+":pull")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token request responded %s", res.Status)
+	}
+	out := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if out.Token != "" {
+		return out.Token, nil
+	}
+	return out.AccessToken, nil
+}
+
+// parseChallenge returns the parameters of a bearer
+// authentication challenge.
+func parseChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return params
+	}
+	for _, part := range strings.Split(challenge[len("bearer "):], ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return params
+}
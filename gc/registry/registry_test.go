@@ -0,0 +1,152 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	existing = "sha256:e4355b66995c96b4b468159fc5c7e3540fcef961189ca13fee877798649f531a"
+	deleted  = "sha256:0c5e3d3f1a3d24d1b4cd8a0ad8ed1ea8b8c7a3bd9b7c7a6f9c3b5c1f5e4d3c2b"
+)
+
+func TestManifestExists(t *testing.T) {
+	var requests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/library/golang/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Method != http.MethodHead {
+			t.Errorf("Want HEAD request, got %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/v2/library/golang/manifests/" + existing, "/v2/library/golang/manifests/1.12":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c, err := New(map[string]string{"registry.local:5000": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	exists, err := c.ManifestExists(ctx, "registry.local:5000/library/golang@"+existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Errorf("Want manifest exists")
+	}
+
+	exists, err = c.ManifestExists(ctx, "registry.local:5000/library/golang@"+deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Errorf("Want deleted manifest missing")
+	}
+
+	// the result is cached.
+	c.ManifestExists(ctx, "registry.local:5000/library/golang@"+deleted)
+	if got, want := atomic.LoadInt32(&requests), int32(2); got != want {
+		t.Errorf("Want %d registry requests, got %d", want, got)
+	}
+
+	// tags are checked by the tag manifest.
+	exists, err = c.ManifestExists(ctx, "registry.local:5000/library/golang:1.12")
+	if err != nil || !exists {
+		t.Errorf("Want tag manifest exists, got %v %v", exists, err)
+	}
+	exists, err = c.ManifestExists(ctx, "registry.local:5000/library/golang:1.11")
+	if err != nil || exists {
+		t.Errorf("Want deleted tag missing, got %v %v", exists, err)
+	}
+
+	// images from other registries are not checked.
+	exists, err = c.ManifestExists(ctx, "golang@"+deleted)
+	if err != nil || !exists {
+		t.Errorf("Want manifest of unchecked registry assumed to exist")
+	}
+}
+
+func TestManifestExists_Token(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Query().Get("scope"), "repository:library/golang:pull"; got != want {
+			t.Errorf("Want token scope %q, got %q", want, got)
+		}
+		w.Write([]byte(`{"token":"3fa2b9a4"}`))
+	})
+	var server *httptest.Server
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer 3fa2b9a4" {
+			w.Header().Set("Www-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	c, _ := New(map[string]string{"registry.local:5000": server.URL})
+	exists, err := c.ManifestExists(context.Background(), "registry.local:5000/library/golang@"+existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Errorf("Want manifest exists")
+	}
+}
+
+func TestManifestExists_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c, _ := New(map[string]string{"registry.local:5000": server.URL})
+	if _, err := c.ManifestExists(context.Background(), "registry.local:5000/library/golang@"+existing); err == nil {
+		t.Errorf("Want error when the registry fails")
+	}
+	if _, err := c.ManifestExists(context.Background(), "registry.local:5000/library/golang"); err == nil {
+		t.Errorf("Want error for a reference without digest or tag")
+	}
+}
+
+// this test verifies that images pulled through a registry
+// alias are checked in the canonical registry.
+func TestManifestExists_Alias(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	c, _ := New(
+		map[string]string{"docker.io": server.URL},
+		WithAliases(map[string]string{"mirror.internal": "docker.io"}),
+	)
+	exists, err := c.ManifestExists(context.Background(), "mirror.internal/golang@"+existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Errorf("Want manifest missing in the canonical registry")
+	}
+	if got, want := path, "/v2/library/golang/manifests/"+existing; got != want {
+		t.Errorf("Want request path %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Regret is an image that was pulled or used again shortly
+// after it was evicted.
+type Regret struct {
+	Image string `json:"image"`
+	Count int    `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// RegretStats summarises the eviction regret since startup.
+type RegretStats struct {
+	Evictions int      // image references evicted
+	Regrets   int      // evicted references used again
+	Bytes     int64    // bytes downloaded again
+	Worst     []Regret // most regretted images, descending
+}
+
+// Rate returns the ratio of evictions that were regretted.
+func (s RegretStats) Rate() float64 {
+	if s.Evictions == 0 {
+		return 0
+	}
+	return float64(s.Regrets) / float64(s.Evictions)
+}
+
+// RegretTracker is implemented by backends that track images
+// used again shortly after eviction.
+type RegretTracker interface {
+	Regret() RegretStats
+}
+
+// logRegret logs the eviction regret, if tracked by the
+// backend.
+func (c *collector) logRegret(ctx context.Context) {
+	tracker, ok := c.client.(RegretTracker)
+	if !ok {
+		return
+	}
+	stats := tracker.Regret()
+	if stats.Evictions == 0 {
+		return
+	}
+	log.Ctx(ctx).Info().
+		Int("evictions", stats.Evictions).
+		Int("regrets", stats.Regrets).
+		Float64("rate", stats.Rate()).
+		Int64("bytes", stats.Bytes).
+		Interface("worst", stats.Worst).
+		Msg("eviction regret")
+}
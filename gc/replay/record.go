@@ -0,0 +1,180 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+// Package replay records the runtime event stream and disk
+// usage of a host, and replays the recording against an
+// eviction policy to evaluate its impact.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+
+	"docker.io/go-docker/api/types/events"
+	"github.com/rs/zerolog/log"
+)
+
+// Entry is a recorded runtime event or disk usage snapshot.
+type Entry struct {
+	Time  time.Time       `json:"time"`
+	Host  string          `json:"host,omitempty"`
+	Event *events.Message `json:"event,omitempty"`
+	Image *Image          `json:"image,omitempty"`
+	Usage *Usage          `json:"usage,omitempty"`
+}
+
+// Image is a recorded image.
+type Image struct {
+	ID      string   `json:"id"`
+	Tags    []string `json:"tags,omitempty"`
+	Size    int64    `json:"size"`
+	Created int64    `json:"created"`
+}
+
+// Usage is a recorded disk usage snapshot.
+type Usage struct {
+	LayersSize int64   `json:"layers_size"`
+	Images     []Image `json:"images"`
+}
+
+// Recorder writes the runtime event stream and periodic disk
+// usage snapshots as JSON lines.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecorder returns a recorder that writes to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Record records the backend until the context is cancelled.
+// The event stream is re-connected if disconnected.
+func (r *Recorder) Record(ctx context.Context, host string, backend gc.Backend, interval time.Duration) error {
+	logger := log.Ctx(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	r.snapshot(ctx, host, backend)
+	for {
+		eventc, errc := backend.Events(ctx, recordOpts)
+	events:
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+				r.snapshot(ctx, host, backend)
+			case err := <-errc:
+				logger.Warn().
+					Err(err).
+					Msg("recorder disconnected from event stream")
+				break events
+			case event := <-eventc:
+				r.event(ctx, host, backend, event)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Minute):
+			// wait before reconnecting
+		}
+	}
+}
+
+// event records the runtime event, and the image details if
+// the event is an image pull.
+func (r *Recorder) event(ctx context.Context, host string, backend gc.Backend, event events.Message) {
+	entry := Entry{
+		Time:  time.Unix(0, event.TimeNano),
+		Host:  host,
+		Event: &event,
+	}
+	if event.TimeNano == 0 {
+		entry.Time = time.Unix(event.Time, 0)
+	}
+	if event.Type == events.ImageEventType && event.Action == "pull" {
+		info, err := backend.ImageInspect(ctx, event.ID)
+		if err == nil {
+			created, _ := time.Parse(time.RFC3339Nano, info.Created)
+			entry.Image = &Image{
+				ID:      info.ID,
+				Tags:    info.RepoTags,
+				Size:    info.Size,
+				Created: created.Unix(),
+			}
+		}
+	}
+	r.write(ctx, entry)
+}
+
+// snapshot records the disk usage.
+func (r *Recorder) snapshot(ctx context.Context, host string, backend gc.Backend) {
+	df, err := backend.DiskUsage(ctx)
+	if err != nil {
+		log.Ctx(ctx).Warn().
+			Err(err).
+			Msg("recorder cannot get disk usage")
+		return
+	}
+	usage := &Usage{LayersSize: df.LayersSize}
+	for _, image := range df.Images {
+		usage.Images = append(usage.Images, Image{
+			ID:      image.ID,
+			Tags:    image.RepoTags,
+			Size:    image.Size,
+			Created: image.Created,
+		})
+	}
+	r.write(ctx, Entry{
+		Time:  time.Now(),
+		Host:  host,
+		Usage: usage,
+	})
+}
+
+func (r *Recorder) write(ctx context.Context, entry Entry) {
+	r.mu.Lock()
+	err := r.enc.Encode(entry)
+	r.mu.Unlock()
+	if err != nil {
+		log.Ctx(ctx).Warn().
+			Err(err).
+			Msg("cannot write recording")
+	}
+}
+
+// Read reads the recorded entries.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// recordOpts filters the event stream to the events consumed
+// by the cache listener, and the container destroy events
+// replayed by the simulator.
+var recordOpts = gc.EventOptions{
+	Types:   []string{"container", "image"},
+	Actions: []string{"create", "start", "destroy", "pull", "tag", "untag", "delete"},
+}
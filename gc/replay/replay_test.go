@@ -0,0 +1,159 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package replay
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-gc/gc/memory"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/events"
+)
+
+func TestRecord(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := memory.New()
+	backend.AddImage(types.ImageSummary{ID: "sha256:a180b24e38ed", RepoTags: []string{"golang:1"}, Size: 100})
+
+	w := &lineWriter{lines: make(chan struct{}, 10)}
+	done := make(chan struct{})
+	go func() {
+		NewRecorder(w).Record(ctx, "agent1", backend, time.Hour)
+		close(done)
+	}()
+	for backend.Listeners() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	backend.AddContainer(types.Container{ID: "c3d2a6307f4e", Image: "golang:1"})
+	backend.ContainerRemove(ctx, "c3d2a6307f4e")
+	backend.ImageRemove(ctx, "golang:1", types.ImageRemoveOptions{})
+	for i := 0; i < 5; i++ {
+		select {
+		case <-w.lines:
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for recording")
+		}
+	}
+	cancel()
+	<-done
+
+	entries, err := Read(&w.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("Want snapshot and 4 events recorded, got %d entries", len(entries))
+	}
+	if entries[0].Usage == nil || entries[0].Usage.Images[0].Size != 100 {
+		t.Errorf("Want disk usage snapshot recorded first")
+	}
+	if entries[1].Event == nil || entries[1].Event.Action != "create" || entries[1].Host != "agent1" {
+		t.Errorf("Want container create event recorded")
+	}
+	// image untag and delete events are recorded, since the
+	// cache listener consumes them.
+	if entries[3].Event == nil || entries[3].Event.Action != "untag" {
+		t.Errorf("Want image untag event recorded")
+	}
+	if entries[4].Event == nil || entries[4].Event.Action != "delete" {
+		t.Errorf("Want image delete event recorded")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	start := time.Unix(1546300800, 0)
+	entries := []Entry{
+		{
+			Time: start,
+			Usage: &Usage{
+				Images: []Image{
+					{ID: "sha256:a180b24e38ed", Tags: []string{"golang:1"}, Size: 100, Created: start.Add(-3 * time.Hour).Unix()},
+					{ID: "sha256:481995377a04", Tags: []string{"node:10"}, Size: 100, Created: start.Add(-2 * time.Hour).Unix()},
+					{ID: "sha256:e3d0f1751532", Tags: []string{"redis:5"}, Size: 100, Created: start.Add(-1 * time.Hour).Unix()},
+				},
+			},
+		},
+		containerEvent(start.Add(time.Minute), "create", "golang:1"),
+		containerEvent(start.Add(2*time.Minute), "destroy", "golang:1"),
+		containerEvent(start.Add(10*time.Minute), "create", "golang:1"),
+	}
+
+	tests := []struct {
+		policy  string
+		hits    int
+		repulls int64
+	}{
+		// the recently used golang image is retained.
+		{policy: PolicyLRU, hits: 2, repulls: 0},
+		// the oldest golang image is evicted, and pulled
+		// again when used.
+		{policy: PolicyFIFO, hits: 1, repulls: 100},
+	}
+	for _, test := range tests {
+		report, err := Simulate(context.Background(), entries, Config{
+			Policy:    test.policy,
+			Threshold: 250,
+			Interval:  5 * time.Minute,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Requests != 2 || report.Hits != test.hits {
+			t.Errorf("Want %s %d hits of 2 requests, got %d of %d", test.policy, test.hits, report.Hits, report.Requests)
+		}
+		if report.BytesRepulled != test.repulls {
+			t.Errorf("Want %s %d bytes re-pulled, got %d", test.policy, test.repulls, report.BytesRepulled)
+		}
+		if report.PeakDiskUsed != 300 {
+			t.Errorf("Want %s peak disk used 300, got %d", test.policy, report.PeakDiskUsed)
+		}
+		if report.Evictions != 1 {
+			t.Errorf("Want %s 1 eviction, got %d", test.policy, report.Evictions)
+		}
+	}
+}
+
+func TestSimulate_MultipleHosts(t *testing.T) {
+	entries := []Entry{
+		{Host: "agent1", Usage: &Usage{}},
+		{Host: "agent2", Usage: &Usage{}},
+	}
+	if _, err := Simulate(context.Background(), entries, Config{}); err == nil {
+		t.Errorf("Want error replaying multiple hosts")
+	}
+	if _, err := Simulate(context.Background(), entries, Config{Host: "agent2"}); err != nil {
+		t.Error(err)
+	}
+}
+
+// lineWriter signals each line written.
+type lineWriter struct {
+	buf   bytes.Buffer
+	lines chan struct{}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n, err := w.buf.Write(p)
+	w.lines <- struct{}{}
+	return n, err
+}
+
+func containerEvent(t time.Time, action, image string) Entry {
+	return Entry{
+		Time: t,
+		Event: &events.Message{
+			Type:   events.ContainerEventType,
+			Action: action,
+			ID:     "c3d2a6307f4e",
+			From:   image,
+		},
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package replay
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/drone/drone-gc/gc"
+	"github.com/drone/drone-gc/gc/audit"
+	"github.com/drone/drone-gc/gc/cache"
+	"github.com/drone/drone-gc/gc/internal"
+	"github.com/drone/drone-gc/gc/memory"
+
+	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/events"
+)
+
+// Eviction policies.
+const (
+	// PolicyLRU evicts the least recently used images first.
+	PolicyLRU = "lru"
+
+	// PolicyFIFO evicts the oldest images first, ignoring
+	// image use.
+	PolicyFIFO = "fifo"
+)
+
+// Config configures the simulation.
+type Config struct {
+	// Policy is the eviction policy. Defaults to lru.
+	Policy string
+
+	// Threshold is the image cache size in bytes.
+	Threshold int64
+
+	// MinImageAge is the minimum image age before removal.
+	MinImageAge time.Duration
+
+	// Interval is the collection interval.
+	Interval time.Duration
+
+	// Host limits the replay to the recorded host. The
+	// recording must contain a single host if empty.
+	Host string
+
+	// Options are additional collector options.
+	Options []gc.Option
+}
+
+// Report is the result of a simulation.
+type Report struct {
+	Requests      int     `json:"requests"`
+	Hits          int     `json:"hits"`
+	HitRatio      float64 `json:"hit_ratio"`
+	Repulls       int     `json:"repulls"`
+	BytesRepulled int64   `json:"bytes_repulled"`
+	PeakDiskUsed  int64   `json:"peak_disk_used"`
+	Collections   int     `json:"collections"`
+	Evictions     int     `json:"evictions"`
+}
+
+// Simulate replays the recorded entries against the policy
+// using a virtual clock. Every container create event is an
+// image request, which is a hit if the image is cached and a
+// miss otherwise, in which case the image is pulled again.
+// Images first seen in a snapshot or pull are added without
+// counting as a request, since their pull does not depend on
+// the policy. Container start and image tag events count as
+// image use. Image untag and delete events are not replayed,
+// since they include the evictions of the recorded host.
+func Simulate(ctx context.Context, entries []Entry, conf Config) (*Report, error) {
+	entries, err := filterHost(entries, conf.Host)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return new(Report), nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	if conf.Interval <= 0 {
+		conf.Interval = 5 * time.Minute
+	}
+
+	s := &simulation{
+		report:  new(Report),
+		catalog: map[string]Image{},
+		tags:    map[string]string{},
+	}
+	clock := func() time.Time { return s.now }
+	s.backend = memory.New(memory.WithClock(clock))
+
+	var backend gc.Backend = s.backend
+	switch conf.Policy {
+	case "", PolicyLRU:
+		s.tracker = cache.NewTracker(s.backend, cache.WithClock(clock))
+		backend = s.tracker.Backend()
+	case PolicyFIFO:
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", conf.Policy)
+	}
+
+	opts := []gc.Option{
+		gc.WithThreshold(conf.Threshold),
+		gc.WithMinImageAge(conf.MinImageAge),
+		gc.WithClock(clock),
+	}
+	opts = append(opts, conf.Options...)
+	opts = append(opts, gc.WithAuditLog(s))
+	collector := gc.New(backend, opts...)
+
+	next := entries[0].Time.Add(conf.Interval)
+	for _, entry := range entries {
+		for !next.After(entry.Time) {
+			s.now = next
+			collector.Collect(ctx)
+			s.report.Collections++
+			next = next.Add(conf.Interval)
+		}
+		s.now = entry.Time
+		s.apply(ctx, entry)
+		s.measure(ctx)
+	}
+
+	if s.report.Requests != 0 {
+		s.report.HitRatio = float64(s.report.Hits) / float64(s.report.Requests)
+	}
+	return s.report, nil
+}
+
+type simulation struct {
+	now     time.Time
+	backend *memory.Backend
+	tracker *cache.Tracker
+	report  *Report
+	catalog map[string]Image  // images by id
+	tags    map[string]string // image ids by expanded tag
+}
+
+// apply applies the recorded entry to the simulated backend.
+func (s *simulation) apply(ctx context.Context, entry Entry) {
+	if entry.Usage != nil {
+		for _, image := range entry.Usage.Images {
+			s.discover(image)
+		}
+	}
+	if entry.Image != nil {
+		s.discover(*entry.Image)
+	}
+	if entry.Event == nil {
+		return
+	}
+	event := *entry.Event
+	if event.Type == events.ImageEventType {
+		if event.Action == "tag" && s.tracker != nil {
+			s.tracker.Observe(ctx, event)
+		}
+		return
+	}
+	if event.Type != events.ContainerEventType {
+		return
+	}
+	switch event.Action {
+	case "create":
+		s.request(ctx, event.From)
+		s.backend.AddContainer(types.Container{
+			ID:    event.ID,
+			Image: event.From,
+			State: "running",
+		})
+		if s.tracker != nil {
+			s.tracker.Observe(ctx, event)
+		}
+	case "start":
+		if s.tracker != nil {
+			s.tracker.Observe(ctx, event)
+		}
+	case "destroy":
+		s.backend.ContainerRemove(ctx, event.ID)
+	}
+}
+
+// discover adds the image to the catalog, and to the backend
+// if the image has not been seen before.
+func (s *simulation) discover(image Image) {
+	_, seen := s.catalog[image.ID]
+	s.catalog[image.ID] = image
+	for _, tag := range image.Tags {
+		s.tags[internal.ExpandImage(tag)] = image.ID
+	}
+	if !seen {
+		s.pull(image)
+	}
+}
+
+// request records an image request, pulling the image again
+// if it was evicted.
+func (s *simulation) request(ctx context.Context, name string) {
+	id, ok := s.tags[internal.ExpandImage(name)]
+	if !ok {
+		if _, ok = s.catalog[name]; !ok {
+			// the image size is unknown.
+			return
+		}
+		id = name
+	}
+	s.report.Requests++
+	if _, err := s.backend.ImageInspect(ctx, id); err == nil {
+		s.report.Hits++
+		return
+	}
+	image := s.catalog[id]
+	s.report.Repulls++
+	s.report.BytesRepulled += image.Size
+	s.pull(image)
+}
+
+func (s *simulation) pull(image Image) {
+	s.backend.AddImage(types.ImageSummary{
+		ID:       image.ID,
+		RepoTags: image.Tags,
+		Size:     image.Size,
+		Created:  image.Created,
+	})
+}
+
+// measure updates the peak disk used.
+func (s *simulation) measure(ctx context.Context) {
+	df, _ := s.backend.DiskUsage(ctx)
+	if df.LayersSize > s.report.PeakDiskUsed {
+		s.report.PeakDiskUsed = df.LayersSize
+	}
+}
+
+// Log counts the images removed by the collector.
+func (s *simulation) Log(record audit.Record) error {
+	if record.Kind == "image" && record.Action == "remove" && record.Outcome == audit.OutcomeSuccess {
+		s.report.Evictions++
+	}
+	return nil
+}
+
+// filterHost returns the entries recorded for the host.
+func filterHost(entries []Entry, host string) ([]Entry, error) {
+	var out []Entry
+	for _, entry := range entries {
+		switch {
+		case host == "" && len(out) != 0 && entry.Host != out[0].Host:
+			return nil, fmt.Errorf("recording contains multiple hosts, %q and %q", out[0].Host, entry.Host)
+		case host == "" || entry.Host == host:
+			out = append(out, entry)
+		}
+	}
+	return out, nil
+}
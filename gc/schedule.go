@@ -0,0 +1,317 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/drone/drone-gc/gc/cron"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultInterval is the default interval at which the
+// collector is executed.
+const DefaultInterval = 5 * time.Minute
+
+// ErrInvalidInterval is returned by the scheduler when the
+// interval is not positive and no cron expression is set.
+var ErrInvalidInterval = errors.New("invalid collection interval")
+
+// ErrTooManyFailures is returned by the scheduler when the
+// collector fails more than the maximum consecutive times.
+var ErrTooManyFailures = errors.New("too many consecutive collection failures")
+
+// Scheduler executes the garbage collector on a recurring
+// schedule.
+type Scheduler struct {
+	failures int64 // accessed atomically
+
+	collector Collector
+	interval  time.Duration
+	cron      *cron.Expression
+	location  *time.Location
+	jitter    time.Duration
+	startup   bool
+	blackouts []Window
+	random    *rand.Rand
+
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxFailures int
+
+	trigger  <-chan struct{}
+	debounce time.Duration
+}
+
+// ScheduleOption configures a scheduler option.
+type ScheduleOption func(*Scheduler)
+
+// WithInterval returns an option to execute the collector
+// at a fixed interval.
+func WithInterval(interval time.Duration) ScheduleOption {
+	return func(s *Scheduler) {
+		s.interval = interval
+	}
+}
+
+// WithCron returns an option to execute the collector
+// according to a cron expression. The cron expression takes
+// precedence over the interval.
+func WithCron(expr *cron.Expression) ScheduleOption {
+	return func(s *Scheduler) {
+		s.cron = expr
+	}
+}
+
+// WithLocation returns an option to set the timezone used
+// to evaluate the cron expression. Defaults to UTC.
+func WithLocation(loc *time.Location) ScheduleOption {
+	return func(s *Scheduler) {
+		s.location = loc
+	}
+}
+
+// WithJitter returns an option to delay each execution by a
+// random duration up to the given maximum. This prevents a
+// fleet of agents from collecting at the same moment.
+func WithJitter(jitter time.Duration) ScheduleOption {
+	return func(s *Scheduler) {
+		s.jitter = jitter
+	}
+}
+
+// WithRunOnStartup returns an option to execute the collector
+// immediately, instead of waiting for the first scheduled run.
+func WithRunOnStartup(enabled bool) ScheduleOption {
+	return func(s *Scheduler) {
+		s.startup = enabled
+	}
+}
+
+// WithBlackout returns an option to set blackout windows.
+// The collector does not evict images while a blackout
+// window is active; expired containers, networks and volumes
+// are still removed.
+func WithBlackout(windows ...Window) ScheduleOption {
+	return func(s *Scheduler) {
+		s.blackouts = append(s.blackouts, windows...)
+	}
+}
+
+// WithBackoff returns an option to delay the next execution
+// after consecutive failures. The delay starts at the base
+// duration and doubles with each failure, up to the maximum.
+func WithBackoff(base, max time.Duration) ScheduleOption {
+	return func(s *Scheduler) {
+		s.backoff = base
+		s.maxBackoff = max
+	}
+}
+
+// WithMaxFailures returns an option to stop the scheduler
+// with ErrTooManyFailures once the collector fails the given
+// number of consecutive times. Zero means never stop.
+func WithMaxFailures(n int) ScheduleOption {
+	return func(s *Scheduler) {
+		s.maxFailures = n
+	}
+}
+
+// WithTrigger returns an option to execute the collector
+// immediately when the trigger channel is signalled, in
+// addition to the regular schedule.
+func WithTrigger(trigger <-chan struct{}) ScheduleOption {
+	return func(s *Scheduler) {
+		s.trigger = trigger
+	}
+}
+
+// WithDebounce returns an option to wait for the given
+// duration after a trigger before executing the collector,
+// coalescing triggers received in the meantime.
+func WithDebounce(debounce time.Duration) ScheduleOption {
+	return func(s *Scheduler) {
+		s.debounce = debounce
+	}
+}
+
+// NewScheduler returns a scheduler for the collector.
+func NewScheduler(collector Collector, opt ...ScheduleOption) *Scheduler {
+	s := new(Scheduler)
+	s.collector = collector
+	s.location = time.UTC
+	s.interval = DefaultInterval
+	s.backoff = time.Minute
+	s.maxBackoff = time.Hour
+	s.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, o := range opt {
+		o(s)
+	}
+	return s
+}
+
+// Run executes the collector on schedule until the context
+// is cancelled.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if s.cron == nil && s.interval <= 0 {
+		return ErrInvalidInterval
+	}
+	logger := log.Ctx(ctx)
+	next := time.Now()
+	if !s.startup {
+		next = s.next(next)
+	}
+	for {
+		wait := time.Until(next) + s.delay()
+		logger.Debug().
+			Time("next", time.Now().Add(wait)).
+			Msg("waiting for next scheduled collection")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.trigger:
+			logger.Info().
+				Msg("collection triggered")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.debounce):
+			}
+			// discard triggers received while waiting.
+			select {
+			case <-s.trigger:
+			default:
+			}
+			if err := s.done(ctx, s.run(ctx)); err != nil {
+				return err
+			}
+			// postpone the scheduled run after a failure.
+			if s.Failures() > 0 {
+				next = s.next(time.Now())
+			}
+		case <-time.After(wait):
+			if err := s.done(ctx, s.run(ctx)); err != nil {
+				return err
+			}
+			next = s.next(time.Now())
+		}
+	}
+}
+
+// done records the result of a collection. It returns an
+// error if the scheduler should stop.
+func (s *Scheduler) done(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil {
+		atomic.StoreInt64(&s.failures, 0)
+		return nil
+	}
+	failures := atomic.AddInt64(&s.failures, 1)
+	log.Ctx(ctx).Error().
+		Err(err).
+		Int64("failures", failures).
+		Msg("collection failed")
+
+	if s.maxFailures > 0 && failures >= int64(s.maxFailures) {
+		return ErrTooManyFailures
+	}
+	return nil
+}
+
+// Failures returns the number of consecutive failed
+// collections.
+func (s *Scheduler) Failures() int {
+	return int(atomic.LoadInt64(&s.failures))
+}
+
+func (s *Scheduler) run(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("collector panic: %v", r)
+			log.Ctx(ctx).Error().
+				Str("panic", fmt.Sprint(r)).
+				Str("stack", string(debug.Stack())).
+				Msg("recovered from panic")
+		}
+	}()
+	if inWindows(s.blackouts, time.Now()) {
+		log.Ctx(ctx).Info().
+			Msg("blackout window active, image eviction suspended")
+		ctx = withoutImageEviction(ctx)
+	}
+	return s.collector.Collect(ctx)
+}
+
+// next returns the next scheduled execution after t,
+// postponed by the backoff delay after failures.
+func (s *Scheduler) next(t time.Time) time.Time {
+	next := t.Add(s.interval)
+	if s.cron != nil {
+		if v := s.cron.Next(t.In(s.location)); !v.IsZero() {
+			next = v
+		}
+	}
+	return next.Add(s.retryDelay())
+}
+
+// retryDelay returns the backoff delay for the current
+// number of consecutive failures.
+func (s *Scheduler) retryDelay() time.Duration {
+	failures := s.Failures()
+	if failures == 0 || s.backoff <= 0 {
+		return 0
+	}
+	delay := s.backoff
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= s.maxBackoff {
+			return s.maxBackoff
+		}
+	}
+	if delay > s.maxBackoff {
+		return s.maxBackoff
+	}
+	return delay
+}
+
+// delay returns a random delay up to the configured jitter.
+func (s *Scheduler) delay() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(s.random.Int63n(int64(s.jitter)))
+}
+
+// Schedule schedules the garbage collector to execute at the
+// specified interval duration.
+func Schedule(ctx context.Context, collector Collector, interval time.Duration) error {
+	return NewScheduler(collector, WithInterval(interval)).Run(ctx)
+}
+
+type contextKey int
+
+const imageEvictionKey contextKey = iota
+
+// withoutImageEviction returns a copy of the context that
+// instructs the collector to skip image eviction.
+func withoutImageEviction(ctx context.Context) context.Context {
+	return context.WithValue(ctx, imageEvictionKey, false)
+}
+
+// imageEvictionEnabled returns false if image eviction is
+// suspended for the context.
+func imageEvictionEnabled(ctx context.Context) bool {
+	enabled, ok := ctx.Value(imageEvictionKey).(bool)
+	return !ok || enabled
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-gc/gc/cron"
+)
+
+func TestScheduler_RunOnStartup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	collector := &collectFunc{
+		fn: func(ctx context.Context) error {
+			if !imageEvictionEnabled(ctx) {
+				t.Errorf("Want image eviction enabled outside blackout windows")
+			}
+			cancel()
+			return nil
+		},
+	}
+	s := NewScheduler(collector,
+		WithInterval(time.Hour),
+		WithRunOnStartup(true),
+	)
+	if err := s.Run(ctx); err != context.Canceled {
+		t.Errorf("Want context cancelled error, got %v", err)
+	}
+	if got, want := collector.calls, 1; got != want {
+		t.Errorf("Want %d collections, got %d", want, got)
+	}
+}
+
+func TestScheduler_Blackout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	collector := &collectFunc{
+		fn: func(ctx context.Context) error {
+			if imageEvictionEnabled(ctx) {
+				t.Errorf("Want image eviction suspended during blackout windows")
+			}
+			cancel()
+			return nil
+		},
+	}
+	s := NewScheduler(collector,
+		WithInterval(time.Hour),
+		WithRunOnStartup(true),
+		WithBlackout(Window{Start: 0, End: 24 * time.Hour}),
+	)
+	s.Run(ctx)
+	if got, want := collector.calls, 1; got != want {
+		t.Errorf("Want %d collections, got %d", want, got)
+	}
+}
+
+func TestScheduler_Next(t *testing.T) {
+	expr, _ := cron.Parse("*/10 * * * *")
+	from := time.Date(2019, time.March, 1, 10, 7, 0, 0, time.UTC)
+
+	s := NewScheduler(nil, WithInterval(time.Minute))
+	if got, want := s.next(from), from.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("Want next interval run at %s, got %s", want, got)
+	}
+	s = NewScheduler(nil, WithInterval(time.Minute), WithCron(expr))
+	if got, want := s.next(from), from.Add(3*time.Minute); !got.Equal(want) {
+		t.Errorf("Want next cron run at %s, got %s", want, got)
+	}
+}
+
+func TestScheduler_InvalidInterval(t *testing.T) {
+	collector := &collectFunc{
+		fn: func(ctx context.Context) error {
+			return nil
+		},
+	}
+	s := NewScheduler(collector, WithInterval(0), WithRunOnStartup(true))
+	if err := s.Run(context.Background()); err != ErrInvalidInterval {
+		t.Errorf("Want invalid interval error, got %v", err)
+	}
+	if got, want := collector.calls, 0; got != want {
+		t.Errorf("Want %d collections, got %d", want, got)
+	}
+}
+
+func TestScheduler_Jitter(t *testing.T) {
+	s := NewScheduler(nil, WithJitter(time.Minute))
+	for i := 0; i < 100; i++ {
+		if d := s.delay(); d < 0 || d >= time.Minute {
+			t.Errorf("Want jitter in range [0, 1m), got %s", d)
+		}
+	}
+}
+
+type collectFunc struct {
+	calls int
+	fn    func(context.Context) error
+}
+
+func (c *collectFunc) Collect(ctx context.Context) error {
+	c.calls++
+	return c.fn(ctx)
+}
+
+func TestScheduler_MaxFailures(t *testing.T) {
+	collector := &collectFunc{
+		fn: func(ctx context.Context) error {
+			return errors.New("cannot list containers")
+		},
+	}
+	s := NewScheduler(collector,
+		WithInterval(time.Millisecond),
+		WithRunOnStartup(true),
+		WithBackoff(0, 0),
+		WithMaxFailures(3),
+	)
+	if err := s.Run(context.Background()); err != ErrTooManyFailures {
+		t.Errorf("Want too many failures error, got %v", err)
+	}
+	if got, want := collector.calls, 3; got != want {
+		t.Errorf("Want %d collections, got %d", want, got)
+	}
+	if got, want := s.Failures(), 3; got != want {
+		t.Errorf("Want %d consecutive failures, got %d", want, got)
+	}
+}
+
+func TestScheduler_RecoverPanic(t *testing.T) {
+	collector := &collectFunc{
+		fn: func(ctx context.Context) error {
+			var labels map[string]string
+			labels["io.drone.expires"] = "915148800"
+			return nil
+		},
+	}
+	s := NewScheduler(collector)
+	if err := s.run(context.Background()); err == nil {
+		t.Errorf("Want error returned from recovered panic")
+	}
+}
+
+func TestScheduler_Backoff(t *testing.T) {
+	from := time.Date(2019, time.March, 1, 10, 0, 0, 0, time.UTC)
+	s := NewScheduler(nil,
+		WithInterval(5*time.Minute),
+		WithBackoff(2*time.Minute, 20*time.Minute),
+	)
+	var tests = []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{0, 5 * time.Minute},
+		{1, 7 * time.Minute},
+		{2, 9 * time.Minute},
+		{3, 13 * time.Minute},
+		{4, 21 * time.Minute},
+		{5, 25 * time.Minute},
+		{50, 25 * time.Minute},
+	}
+	for _, test := range tests {
+		s.failures = test.failures
+		if got, want := s.next(from).Sub(from), test.want; got != want {
+			t.Errorf("Want delay %s after %d failures, got %s", want, test.failures, got)
+		}
+	}
+}
+
+func TestScheduler_Trigger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	trigger := make(chan struct{}, 1)
+	trigger <- struct{}{}
+
+	collector := &collectFunc{
+		fn: func(ctx context.Context) error {
+			cancel()
+			return nil
+		},
+	}
+	s := NewScheduler(collector,
+		WithInterval(time.Hour),
+		WithTrigger(trigger),
+		WithDebounce(time.Millisecond),
+	)
+	s.Run(ctx)
+	if got, want := collector.calls, 1; got != want {
+		t.Errorf("Want %d triggered collections, got %d", want, got)
+	}
+}
+
+// this test verifies that a failed triggered collection
+// postpones the next scheduled collection.
+func TestScheduler_TriggerBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	trigger := make(chan struct{}, 1)
+	trigger <- struct{}{}
+
+	collector := &collectFunc{
+		fn: func(ctx context.Context) error {
+			return errors.New("cannot get disk usage")
+		},
+	}
+	s := NewScheduler(collector,
+		WithInterval(50*time.Millisecond),
+		WithBackoff(time.Hour, time.Hour),
+		WithTrigger(trigger),
+	)
+	s.Run(ctx)
+	if got, want := collector.calls, 1; got != want {
+		t.Errorf("Want %d collections, got %d", want, got)
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import "errors"
+
+// statfs returns the free and total bytes of the filesystem
+// containing the path. It is not supported on windows.
+func statfs(path string) (free, total int64, err error) {
+	return 0, 0, errors.New("filesystem usage not supported on windows")
+}
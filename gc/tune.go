@@ -0,0 +1,281 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// the observation window of the tuner.
+	tuneWindow = 24 * time.Hour
+
+	// regret rate above which the cache is considered too
+	// small, and below which it can be reduced.
+	tuneRegretHigh = 0.05
+	tuneRegretLow  = 0.01
+
+	// fraction of the root filesystem kept free.
+	tuneReserve = 0.1
+)
+
+// Tuner recommends an image cache threshold for the host
+// based on the eviction regret, the churn rate and the free
+// space on the Docker root filesystem. In auto mode, the
+// effective threshold is adjusted within the bounds.
+//
+// The recommendation is a factor of the threshold in effect,
+// so that the thresholds of time-of-day profiles are scaled
+// by the same factor. The bounds apply to the base threshold,
+// and proportionally to the profile thresholds.
+type Tuner struct {
+	mu sync.Mutex
+
+	root    string
+	base    int64
+	min     int64
+	max     int64
+	auto    bool
+	statfs  func(string) (free, total int64, err error)
+	samples []tuneSample
+	last    RegretStats
+
+	factor      float64
+	recommended int64
+}
+
+type tuneSample struct {
+	time        time.Time
+	evicted     int64
+	evictions   int
+	regrets     int
+	regretBytes int64
+	free        int64
+	total       int64
+}
+
+// NewTuner returns a threshold tuner for the base threshold.
+// The root is the path of the Docker root filesystem, and may
+// be empty if the free space is unknown. Zero bounds are
+// unbounded. If auto is true, the effective threshold is
+// adjusted to the recommendation.
+func NewTuner(root string, base, min, max int64, auto bool) *Tuner {
+	return &Tuner{
+		root:   root,
+		base:   base,
+		min:    min,
+		max:    max,
+		auto:   auto,
+		statfs: statfs,
+	}
+}
+
+// WithTuner returns an option to set the threshold tuner.
+func WithTuner(tuner *Tuner) Option {
+	return func(c *collector) {
+		c.tuner = tuner
+	}
+}
+
+// Recommended returns the recommended base threshold, or zero
+// if no collection cycle has completed.
+func (t *Tuner) Recommended() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.recommended
+}
+
+// threshold returns the configured threshold of the profile
+// scaled by the tuned factor in auto mode, and the configured
+// threshold otherwise.
+func (t *Tuner) threshold(configured int64) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.auto && t.factor != 0 {
+		return int64(float64(configured) * t.factor)
+	}
+	return configured
+}
+
+// observe records the collection cycle and updates the
+// recommendation. The configured threshold is the threshold
+// of the profile, and the threshold the one in effect.
+func (t *Tuner) observe(ctx context.Context, now time.Time, configured, threshold, evicted int64, regret RegretStats) {
+	logger := log.Ctx(ctx)
+	s := tuneSample{time: now, evicted: evicted}
+	if t.root != "" {
+		free, total, err := t.statfs(t.root)
+		if err != nil {
+			logger.Warn().
+				Err(err).
+				Str("root", t.root).
+				Msg("cannot get root filesystem usage")
+		} else {
+			s.free, s.total = free, total
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.evictions = regret.Evictions - t.last.Evictions
+	s.regrets = regret.Regrets - t.last.Regrets
+	s.regretBytes = regret.Bytes - t.last.Bytes
+	t.last = regret
+	t.samples = append(t.samples, s)
+	for len(t.samples) > 1 && now.Sub(t.samples[0].time) > tuneWindow {
+		t.samples = t.samples[1:]
+	}
+
+	recommended, reason := t.recommend(threshold)
+	recommended = t.clamp(recommended, configured)
+
+	// the recommendation is reported for the base threshold,
+	// which is the same for all profiles.
+	base := recommended
+	if configured > 0 && t.base > 0 {
+		base = int64(float64(recommended) * float64(t.base) / float64(configured))
+	}
+	event := logger.Debug()
+	if base != t.recommended {
+		event = logger.Info()
+	}
+	event.
+		Str("threshold", units.HumanSize(float64(threshold))).
+		Str("recommended", units.HumanSize(float64(base))).
+		Int64("recommended-bytes", base).
+		Str("reason", reason).
+		Msg("cache threshold recommendation")
+	t.recommended = base
+
+	if t.auto && configured > 0 && recommended != threshold {
+		logger.Info().
+			Str("from", units.HumanSize(float64(threshold))).
+			Str("to", units.HumanSize(float64(recommended))).
+			Str("reason", reason).
+			Msg("cache threshold adjusted")
+		t.factor = float64(recommended) / float64(configured)
+		// start a new observation window, so that the
+		// same observations are not applied twice.
+		t.samples = nil
+	}
+}
+
+// recommend returns the recommended threshold and the reason.
+// The lock must be held.
+//
+// The threshold grows by the bytes downloaded again after
+// eviction when the regret rate is high, limited by the free
+// space above the reserve. It shrinks by the reserve shortfall
+// when the regret rate is low and the root filesystem is
+// nearly full, by at most the bytes evicted per hour so that
+// a reduction does not cause a burst of evictions.
+func (t *Tuner) recommend(threshold int64) (int64, string) {
+	var (
+		evicted     int64
+		regretBytes int64
+		evictions   int
+		regrets     int
+		free        int64
+		total       int64
+		measured    int64
+	)
+	for _, s := range t.samples {
+		evicted += s.evicted
+		regretBytes += s.regretBytes
+		evictions += s.evictions
+		regrets += s.regrets
+		if s.total != 0 {
+			free += s.free
+			total += s.total
+			measured++
+		}
+	}
+
+	var rate float64
+	if evictions != 0 {
+		rate = float64(regrets) / float64(evictions)
+	}
+
+	// headroom is the average free space above the reserve.
+	known := measured != 0
+	var headroom int64
+	if known {
+		headroom = (free - int64(float64(total)*tuneReserve)) / measured
+	}
+
+	// churn is the number of bytes evicted per hour.
+	var churn int64
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+	if d := last.time.Sub(first.time); d >= time.Hour {
+		churn = int64(float64(evicted) / d.Hours())
+	} else {
+		churn = evicted
+	}
+
+	recommended, reason := threshold, "steady"
+	switch {
+	case regrets != 0 && rate >= tuneRegretHigh:
+		grow := regretBytes
+		reason = "high eviction regret"
+		if known && grow > headroom {
+			grow = headroom
+			reason = "high eviction regret, limited by disk headroom"
+		}
+		if grow > 0 {
+			recommended += grow
+		}
+	case known && headroom < 0 && rate < tuneRegretLow:
+		shrink := -headroom
+		if churn > 0 && shrink > churn {
+			shrink = churn
+		}
+		recommended -= shrink
+		reason = "low disk headroom"
+	}
+	return recommended, reason
+}
+
+// clamp returns the threshold within the bounds, scaled from
+// the base threshold to the configured threshold.
+func (t *Tuner) clamp(threshold, configured int64) int64 {
+	min, max := t.min, t.max
+	if t.base > 0 && configured > 0 {
+		scale := float64(configured) / float64(t.base)
+		min = int64(float64(min) * scale)
+		max = int64(float64(max) * scale)
+	}
+	if min > 0 && threshold < min {
+		return min
+	}
+	if max > 0 && threshold > max {
+		return max
+	}
+	if threshold < 0 {
+		return 0
+	}
+	return threshold
+}
+
+// tune records the collection cycle with the tuner. The
+// configured threshold is the threshold of the profile before
+// tuning.
+func (c *collector) tune(ctx context.Context, configured int64) {
+	if c.tuner == nil {
+		return
+	}
+	var regret RegretStats
+	if tracker, ok := c.client.(RegretTracker); ok {
+		regret = tracker.Regret()
+	}
+	c.spent.Lock()
+	evicted := c.spent.bytes
+	c.spent.Unlock()
+	c.tuner.observe(ctx, c.now(), configured, c.threshold, evicted, regret)
+}
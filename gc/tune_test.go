@@ -0,0 +1,95 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTuner_Regret(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	tuner := NewTuner("/var/lib/docker", 1000, 0, 0, false)
+	tuner.statfs = func(string) (int64, int64, error) {
+		return 500, 1000, nil
+	}
+
+	// 2 of 10 evictions were regretted, which downloaded
+	// 300 bytes again.
+	tuner.observe(context.Background(), now, 1000, 1000, 600, RegretStats{Evictions: 10, Regrets: 2, Bytes: 300})
+	if got, want := tuner.Recommended(), int64(1300); got != want {
+		t.Errorf("Want recommended threshold %d, got %d", want, got)
+	}
+
+	// the growth is limited by the free space above the
+	// reserve of 100 bytes.
+	tuner.observe(context.Background(), now.Add(time.Hour), 1000, 1000, 600, RegretStats{Evictions: 20, Regrets: 4, Bytes: 600})
+	if got, want := tuner.Recommended(), int64(1400); got != want {
+		t.Errorf("Want recommended threshold %d, got %d", want, got)
+	}
+}
+
+func TestTuner_Headroom(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	tuner := NewTuner("/var/lib/docker", 1000, 0, 0, false)
+	tuner.statfs = func(string) (int64, int64, error) {
+		return 20, 1000, nil
+	}
+
+	// the free space is 80 bytes below the reserve, and
+	// the threshold shrinks by at most the bytes evicted
+	// per hour.
+	tuner.observe(context.Background(), now, 1000, 1000, 50, RegretStats{Evictions: 10})
+	if got, want := tuner.Recommended(), int64(950); got != want {
+		t.Errorf("Want recommended threshold %d, got %d", want, got)
+	}
+}
+
+func TestTuner_Auto(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	tuner := NewTuner("", 1000, 500, 1200, true)
+
+	if got, want := tuner.threshold(1000), int64(1000); got != want {
+		t.Errorf("Want configured threshold %d before tuning, got %d", want, got)
+	}
+	tuner.observe(context.Background(), now, 1000, 1000, 600, RegretStats{Evictions: 10, Regrets: 5, Bytes: 500})
+	if got, want := tuner.threshold(1000), int64(1200); got != want {
+		t.Errorf("Want threshold adjusted to the upper bound %d, got %d", want, got)
+	}
+
+	// observations are not applied twice.
+	tuner.observe(context.Background(), now.Add(time.Hour), 1000, 1200, 0, RegretStats{Evictions: 10, Regrets: 5, Bytes: 500})
+	if got, want := tuner.threshold(1000), int64(1200); got != want {
+		t.Errorf("Want threshold %d, got %d", want, got)
+	}
+}
+
+// this test verifies that the tuned factor applies to the
+// thresholds of all profiles, and that the bounds are scaled
+// to the profile threshold.
+func TestTuner_Profiles(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	tuner := NewTuner("", 1000, 500, 1500, true)
+
+	tuner.observe(context.Background(), now, 1000, 1000, 600, RegretStats{Evictions: 10, Regrets: 5, Bytes: 200})
+	if got, want := tuner.threshold(1000), int64(1200); got != want {
+		t.Errorf("Want base threshold %d, got %d", want, got)
+	}
+	if got, want := tuner.threshold(500), int64(600); got != want {
+		t.Errorf("Want profile threshold scaled to %d, got %d", want, got)
+	}
+
+	// the profile threshold is limited by the upper bound
+	// scaled to the profile, and the recommendation is
+	// reported for the base threshold.
+	tuner.observe(context.Background(), now.Add(time.Hour), 500, 600, 600, RegretStats{Evictions: 20, Regrets: 10, Bytes: 500})
+	if got, want := tuner.threshold(500), int64(750); got != want {
+		t.Errorf("Want profile threshold %d, got %d", want, got)
+	}
+	if got, want := tuner.Recommended(), int64(1500); got != want {
+		t.Errorf("Want recommended base threshold %d, got %d", want, got)
+	}
+}
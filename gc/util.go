@@ -6,11 +6,11 @@ package gc
 
 import (
 	"path"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/drone/drone-gc/gc/internal"
+
+	"docker.io/go-docker/api/types"
 )
 
 func skipState(state string) bool {
@@ -22,8 +22,8 @@ func skipState(state string) bool {
 	}
 }
 
-func skipImage(image string) bool {
-	image = internal.ExpandImage(image)
+func skipImage(image string, registries internal.Registries) bool {
+	image = registries.Expand(image)
 	switch {
 	case strings.HasPrefix(image, "docker.io/drone/"):
 		return true
@@ -34,15 +34,20 @@ func skipImage(image string) bool {
 	}
 }
 
-func matchPatterns(names []string, patterns []string) bool {
+// matchPatterns returns true if a name matches a pattern. The
+// fully qualified name is also matched against the fully
+// qualified pattern, with registry aliases replaced by the
+// canonical registry in both, so that a short pattern matches
+// the mirrored image.
+func matchPatterns(names []string, patterns []string, registries internal.Registries) bool {
 	for _, name := range names {
-		full := internal.ExpandImage(name)
+		full := registries.Expand(name)
 		for _, pattern := range patterns {
 			matched, _ := path.Match(pattern, name)
 			if matched {
 				return true
 			}
-			matched, _ = path.Match(pattern, full)
+			matched, _ = path.Match(registries.ExpandPattern(pattern), full)
 			if matched {
 				return true
 			}
@@ -51,19 +56,15 @@ func matchPatterns(names []string, patterns []string) bool {
 	return false
 }
 
-func isExpired(labels map[string]string) bool {
-	l, ok := labels["io.drone.expires"]
-	if !ok {
-		return false
-	}
-	i, err := strconv.ParseInt(l, 10, 64)
-	if err != nil {
-		return true
-	}
-	t := time.Unix(i, 0)
-	return time.Now().After(t)
-}
-
 func isProtected(labels map[string]string) bool {
 	return labels["io.drone.protected"] == "true"
 }
+
+// imageLabels returns the labels of the image, set at build
+// time.
+func imageLabels(info types.ImageInspect) map[string]string {
+	if info.Config == nil {
+		return nil
+	}
+	return info.Config.Labels
+}
@@ -6,6 +6,8 @@ package gc
 
 import (
 	"testing"
+
+	"github.com/drone/drone-gc/gc/internal"
 )
 
 func TestSkipState(t *testing.T) {
@@ -55,7 +57,7 @@ func TestSkipImage(t *testing.T) {
 		{"docker.io/library/busybox", false},
 	}
 	for _, test := range tests {
-		if got, want := skipImage(test.image), test.want; got != want {
+		if got, want := skipImage(test.image, nil), test.want; got != want {
 			t.Errorf("Want skipImage %v, got %v", want, got)
 		}
 	}
@@ -78,9 +80,40 @@ func TestMatchPatterns(t *testing.T) {
 		{"redis", "docker.io/library/redis:1", false}, // tag mismatch
 	}
 	for _, test := range tests {
-		matched := matchPatterns([]string{test.name}, []string{test.path})
+		matched := matchPatterns([]string{test.name}, []string{test.path}, nil)
 		if got, want := matched, test.want; got != want {
 			t.Errorf("Want matchPatterns %v, got %v", want, got)
 		}
 	}
 }
+
+func TestMatchPatterns_RegistryAliases(t *testing.T) {
+	registries := internal.Registries{
+		"mirror.internal":           "docker.io",
+		"registry.local:5000/cache": "quay.io",
+	}
+	var tests = []struct {
+		name string
+		path string
+		want bool
+	}{
+		{"mirror.internal/library/golang:1", "docker.io/library/golang:*", true},
+		{"mirror.internal/golang:1", "golang:*", true},
+		{"mirror.internal/library/golang:1", "golang:*", true},
+		{"mirror.internal/golang:1", "alpine:*", false},
+		{"golang:1", "mirror.internal/library/golang:*", true},
+		{"golang:1", "mirror.internal/golang:*", true},
+		{"registry.local:5000/cache/coreos/etcd", "quay.io/coreos/etcd:*", true},
+		{"registry.local:5000/coreos/etcd", "quay.io/coreos/etcd:*", false},
+		{"mirror.internal/drone/drone:1", "docker.io/drone/drone:*", true},
+	}
+	for _, test := range tests {
+		matched := matchPatterns([]string{test.name}, []string{test.path}, registries)
+		if got, want := matched, test.want; got != want {
+			t.Errorf("Want matchPatterns %v for %s and %s, got %v", want, test.name, test.path, got)
+		}
+	}
+	if !skipImage("mirror.internal/drone/agent:1", registries) {
+		t.Errorf("Want mirrored drone image skipped")
+	}
+}
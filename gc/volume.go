@@ -6,17 +6,22 @@ package gc
 
 import (
 	"context"
+	"sync"
+	"time"
 
-	"docker.io/go-docker/api/types/filters"
+	"github.com/drone/drone-gc/gc/audit"
+
+	"docker.io/go-docker/api/types"
 	"github.com/hashicorp/go-multierror"
 	"github.com/rs/zerolog/log"
 )
 
 func (c *collector) collectVolumes(ctx context.Context) error {
+	var mu sync.Mutex
 	var result error
 
 	logger := log.Ctx(ctx)
-	volumes, err := c.client.VolumeList(ctx, volumeListArgs)
+	volumes, err := c.client.VolumeList(ctx)
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -24,43 +29,139 @@ func (c *collector) collectVolumes(ctx context.Context) error {
 		return err
 	}
 
-	for _, v := range volumes.Volumes {
+	// volumes mounted by a container cannot be removed, and
+	// are excluded from the default time to live.
+	var mounted map[string]bool
+	if c.volumeTTL > 0 {
+		mounted, err = c.mountedVolumes(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := c.now()
+	pool := c.newPool()
+	for _, v := range volumes {
+		if v.Driver != "local" {
+			continue
+		}
+		if matchPatterns([]string{v.Name}, c.volumeWhitelist, nil) {
+			continue
+		}
 		if isProtected(v.Labels) {
 			logger.Debug().
 				Str("name", v.Name).
 				Msg("volume is protected")
 			continue
 		}
-		if isExpired(v.Labels) == false {
+		if c.volumeTTL > 0 && !hasExpiry(v.Labels) && mounted[v.Name] {
+			logger.Debug().
+				Str("name", v.Name).
+				Msg("volume is in use")
+			continue
+		}
+		// the creation time is only required by the ttl label
+		// and the default time to live.
+		var created time.Time
+		if _, ok := v.Labels["io.drone.ttl"]; ok || (c.volumeTTL > 0 && !hasExpiry(v.Labels)) {
+			created = c.volumeCreated(ctx, v)
+		}
+		expired, reason := c.expired(ctx, v.Name, v.Labels, created, c.volumeTTL, now)
+		if expired == false {
 			logger.Debug().
 				Str("name", v.Name).
 				Msg("volume not expired")
 			continue
 		}
 
-		logger.Debug().
-			Str("name", v.Name).
-			Msg("remove volume")
+		v := v
+		pool.run(ctx, func() {
+			if err := c.removeVolume(ctx, v, reason); err != nil {
+				mu.Lock()
+				result = multierror.Append(result, resourceError{err})
+				mu.Unlock()
+			}
+		})
+	}
+	pool.wait()
+	return result
+}
+
+// mountedVolumes returns the names of the volumes mounted by
+// containers.
+func (c *collector) mountedVolumes(ctx context.Context) (map[string]bool, error) {
+	containers, err := c.client.ContainerList(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Msg("cannot list containers")
+		return nil, err
+	}
+	mounted := map[string]bool{}
+	for _, cc := range containers {
+		for _, m := range cc.Mounts {
+			if m.Type == "volume" {
+				mounted[m.Name] = true
+			}
+		}
+	}
+	return mounted, nil
+}
 
-		err = c.client.VolumeRemove(ctx, v.Name, false)
+// volumeCreated returns the volume creation time, or the zero
+// time if unknown. The volume is inspected if the creation
+// time is missing from the volume list.
+func (c *collector) volumeCreated(ctx context.Context, v *types.Volume) time.Time {
+	createdAt := v.CreatedAt
+	if createdAt == "" {
+		info, err := c.client.VolumeInspect(ctx, v.Name)
 		if err != nil {
-			logger.Error().
+			log.Ctx(ctx).Warn().
 				Err(err).
-				Msg("cannot remove volume")
-			result = multierror.Append(result, err)
-			continue
+				Str("name", v.Name).
+				Msg("cannot inspect volume")
+			return time.Time{}
 		}
-
-		logger.Info().
+		createdAt = info.CreatedAt
+	}
+	created, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		log.Ctx(ctx).Warn().
+			Err(err).
 			Str("name", v.Name).
-			Msg("volume removed")
+			Str("created", createdAt).
+			Msg("cannot parse volume creation time")
+		return time.Time{}
 	}
-	return result
+	return created
 }
 
-var volumeListArgs = filters.NewArgs(
-	filters.KeyValuePair{
-		Key:   "driver",
-		Value: "local",
-	},
-)
+// removeVolume removes the volume.
+func (c *collector) removeVolume(ctx context.Context, v *types.Volume, reason expiry) error {
+	logger := log.Ctx(ctx)
+	logger.Debug().
+		Str("name", v.Name).
+		Msg("remove volume")
+
+	err := c.client.VolumeRemove(ctx, v.Name)
+	c.audit(ctx, audit.Record{
+		Action: "remove",
+		Kind:   "volume",
+		ID:     v.Name,
+		Names:  []string{v.Name},
+		Labels: v.Labels,
+		Policy: reason.policy,
+		Detail: reason.detail,
+	}, err)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Msg("cannot remove volume")
+		return err
+	}
+
+	logger.Info().
+		Str("name", v.Name).
+		Msg("volume removed")
+	return nil
+}
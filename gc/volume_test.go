@@ -14,6 +14,7 @@ import (
 	"github.com/drone/drone-gc/mocks"
 
 	"docker.io/go-docker/api/types"
+	"docker.io/go-docker/api/types/filters"
 	"docker.io/go-docker/api/types/volume"
 	"github.com/golang/mock/gomock"
 )
@@ -27,14 +28,59 @@ func TestCollectVolumes(t *testing.T) {
 			{Name: "a180b24e38ed", Driver: "local", Labels: map[string]string{"io.drone.expires": "915148800"}},
 			{Name: "e3d0f1751532", Driver: "local", Labels: map[string]string{"io.drone.expires": fmt.Sprint(time.Now().Add(time.Hour).Unix())}},
 			{Name: "bfbf8512f21e", Driver: "local", Labels: nil},
+			{Name: "7c5f0c5d8a3b", Driver: "nfs", Labels: map[string]string{"io.drone.expires": "915148800"}},
 		},
 	}
 
 	client := mocks.NewMockAPIClient(controller)
-	client.EXPECT().VolumeList(gomock.Any(), volumeListArgs).Return(mockVolumes, nil)
+	client.EXPECT().VolumeList(gomock.Any(), filters.NewArgs()).Return(mockVolumes, nil)
 	client.EXPECT().VolumeRemove(gomock.Any(), mockVolumes.Volumes[0].Name, false).Return(nil)
 
-	c := New(client).(*collector)
+	c := New(NewDockerBackend(client)).(*collector)
+	err := c.collectVolumes(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// this test verifies that the default time to live skips
+// mounted volumes, even if a malformed expiry label is ignored,
+// and whitelisted volumes, and that volumes with an unknown
+// creation time are inspected.
+func TestCollectVolumes_DefaultTTL(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	now := time.Unix(1192233600, 0)
+	old := now.Add(-25 * time.Hour).Format(time.RFC3339)
+	mockVolumes := volume.VolumesListOKBody{
+		Volumes: []*types.Volume{
+			{Name: "a180b24e38ed", Driver: "local", CreatedAt: old},
+			{Name: "e3d0f1751532", Driver: "local", CreatedAt: now.Add(-time.Hour).Format(time.RFC3339)},
+			{Name: "bfbf8512f21e", Driver: "local"},
+			{Name: "7c5f0c5d8a3b", Driver: "local", CreatedAt: old},
+			{Name: "481995377a04", Driver: "local", CreatedAt: old, Labels: map[string]string{"io.drone.expires": "tomorrow"}},
+			{Name: "ci_cache", Driver: "local", CreatedAt: old},
+			{Name: "0f5a2ae4b25f", Driver: "local", CreatedAt: old, Labels: map[string]string{"io.drone.protected": "true"}},
+		},
+	}
+	mockContainers := []types.Container{
+		{ID: "c3d2a6307f4e", Mounts: []types.MountPoint{{Type: "volume", Name: "7c5f0c5d8a3b"}, {Type: "volume", Name: "481995377a04"}}},
+	}
+
+	client := mocks.NewMockAPIClient(controller)
+	client.EXPECT().VolumeList(gomock.Any(), filters.NewArgs()).Return(mockVolumes, nil)
+	client.EXPECT().ContainerList(gomock.Any(), containerListArgs).Return(mockContainers, nil)
+	client.EXPECT().VolumeInspect(gomock.Any(), "bfbf8512f21e").Return(types.Volume{Name: "bfbf8512f21e", CreatedAt: old}, nil)
+	client.EXPECT().VolumeRemove(gomock.Any(), "a180b24e38ed", false).Return(nil)
+	client.EXPECT().VolumeRemove(gomock.Any(), "bfbf8512f21e", false).Return(nil)
+
+	c := New(NewDockerBackend(client),
+		WithVolumeTTL(24*time.Hour),
+		WithVolumeWhitelist([]string{"ci_*"}),
+		WithMalformedExpiry(MalformedIgnore),
+		WithClock(func() time.Time { return now }),
+	).(*collector)
 	err := c.collectVolumes(context.Background())
 	if err != nil {
 		t.Error(err)
@@ -54,11 +100,11 @@ func TestCollectVolumes_MultiError(t *testing.T) {
 	mockErr := errors.New("cannot remove volume")
 
 	client := mocks.NewMockAPIClient(controller)
-	client.EXPECT().VolumeList(gomock.Any(), volumeListArgs).Return(mockVolumes, nil)
+	client.EXPECT().VolumeList(gomock.Any(), filters.NewArgs()).Return(mockVolumes, nil)
 	client.EXPECT().VolumeRemove(gomock.Any(), mockVolumes.Volumes[0].Name, false).Return(mockErr)
 	client.EXPECT().VolumeRemove(gomock.Any(), mockVolumes.Volumes[1].Name, false).Return(nil)
 
-	c := New(client).(*collector)
+	c := New(NewDockerBackend(client)).(*collector)
 	err := c.collectVolumes(context.Background())
 	if err == nil {
 		t.Errorf("Expected multi-error returned")
@@ -0,0 +1,210 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window defines a recurring time-of-day window, for example
+// working hours on weekdays.
+type Window struct {
+	// Start and End are offsets from midnight. If End is
+	// before Start the window spans midnight.
+	Start time.Duration
+	End   time.Duration
+
+	// Days restricts the window to the given days of the
+	// week, based on the day the window starts. An empty
+	// list matches every day.
+	Days []time.Weekday
+
+	// Location is the timezone used to evaluate the window.
+	// Defaults to UTC if nil.
+	Location *time.Location
+}
+
+// Contains returns true if the time t is inside the window.
+func (w Window) Contains(t time.Time) bool {
+	if w.Location != nil {
+		t = t.In(w.Location)
+	} else {
+		t = t.UTC()
+	}
+	offset := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	switch {
+	case w.Start <= w.End:
+		return offset >= w.Start && offset < w.End && w.matchDay(t.Weekday())
+	case offset >= w.Start:
+		return w.matchDay(t.Weekday())
+	case offset < w.End:
+		// the window started the previous day.
+		return w.matchDay((t.Weekday() + 6) % 7)
+	default:
+		return false
+	}
+}
+
+func (w Window) matchDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the window in the format accepted by
+// ParseWindow.
+func (w Window) String() string {
+	var parts []string
+	if len(w.Days) != 0 {
+		var days []string
+		for _, d := range w.Days {
+			days = append(days, d.String()[:3])
+		}
+		parts = append(parts, strings.Join(days, ","))
+	}
+	parts = append(parts, fmt.Sprintf("%s-%s", formatClock(w.Start), formatClock(w.End)))
+	if w.Location != nil {
+		parts = append(parts, w.Location.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseWindow parses a window in the format
+// "[days] HH:MM-HH:MM [timezone]", for example
+// "Mon-Fri 08:00-18:00 Europe/Berlin" or "22:00-06:00".
+// Days are a comma-separated list of day names or ranges.
+// The location is used if the window has no timezone.
+func ParseWindow(s string, loc *time.Location) (Window, error) {
+	w := Window{Location: loc}
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 3 {
+		return w, fmt.Errorf("invalid window %q", s)
+	}
+
+	// the clock range is the only field that starts
+	// with a digit.
+	clock := -1
+	for i, field := range fields {
+		if field[0] >= '0' && field[0] <= '9' {
+			clock = i
+			break
+		}
+	}
+	if clock == -1 || clock > 1 {
+		return w, fmt.Errorf("invalid window %q: missing time range", s)
+	}
+
+	parts := strings.Split(fields[clock], "-")
+	if len(parts) != 2 {
+		return w, fmt.Errorf("invalid window %q: invalid time range", s)
+	}
+	var err error
+	if w.Start, err = parseClock(parts[0]); err != nil {
+		return w, err
+	}
+	if w.End, err = parseClock(parts[1]); err != nil {
+		return w, err
+	}
+
+	if clock == 1 {
+		if w.Days, err = parseDays(fields[0]); err != nil {
+			return w, err
+		}
+	}
+	if len(fields) > clock+1 {
+		if w.Location, err = time.LoadLocation(fields[clock+1]); err != nil {
+			return w, err
+		}
+	}
+	return w, nil
+}
+
+// ParseWindows parses a semicolon-separated list of windows.
+func ParseWindows(s string, loc *time.Location) ([]Window, error) {
+	var windows []Window
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		w, err := ParseWindow(part, loc)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * time.Hour, nil
+		}
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute, nil
+}
+
+func formatClock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseDays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.Split(strings.ToLower(part), "-")
+		from, ok := weekdays[bounds[0]]
+		if !ok || len(bounds) > 2 {
+			return nil, fmt.Errorf("invalid day %q", part)
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[bounds[1]]; !ok {
+				return nil, fmt.Errorf("invalid day %q", part)
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// inWindows returns true if the time t is inside any of
+// the windows.
+func inWindows(windows []Window, t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
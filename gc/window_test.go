@@ -0,0 +1,74 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	var tests = []struct {
+		window string
+		time   time.Time
+		want   bool
+	}{
+		{"08:00-18:00", time.Date(2019, time.March, 1, 9, 0, 0, 0, time.UTC), true},
+		{"08:00-18:00", time.Date(2019, time.March, 1, 18, 0, 0, 0, time.UTC), false},
+		{"08:00-18:00", time.Date(2019, time.March, 1, 7, 59, 0, 0, time.UTC), false},
+		// overnight windows
+		{"22:00-06:00", time.Date(2019, time.March, 1, 23, 0, 0, 0, time.UTC), true},
+		{"22:00-06:00", time.Date(2019, time.March, 1, 5, 0, 0, 0, time.UTC), true},
+		{"22:00-06:00", time.Date(2019, time.March, 1, 12, 0, 0, 0, time.UTC), false},
+		// days of the week (march 1st 2019 is a friday)
+		{"Mon-Fri 08:00-18:00", time.Date(2019, time.March, 1, 9, 0, 0, 0, time.UTC), true},
+		{"Mon-Fri 08:00-18:00", time.Date(2019, time.March, 2, 9, 0, 0, 0, time.UTC), false},
+		{"Sat,Sun 00:00-24:00", time.Date(2019, time.March, 2, 9, 0, 0, 0, time.UTC), true},
+		{"Fri 22:00-06:00", time.Date(2019, time.March, 2, 5, 0, 0, 0, time.UTC), true},
+		{"Fri 22:00-06:00", time.Date(2019, time.March, 1, 5, 0, 0, 0, time.UTC), false},
+		// timezones
+		{"08:00-18:00 UTC", time.Date(2019, time.March, 1, 7, 0, 0, 0, loc), false},
+		{"08:00-18:00 Etc/GMT-2", time.Date(2019, time.March, 1, 7, 0, 0, 0, time.UTC), true},
+	}
+	for _, test := range tests {
+		w, err := ParseWindow(test.window, time.UTC)
+		if err != nil {
+			t.Errorf("Cannot parse window %q: %s", test.window, err)
+			continue
+		}
+		if got, want := w.Contains(test.time), test.want; got != want {
+			t.Errorf("Want window %q contains %s %v, got %v", test.window, test.time, want, got)
+		}
+	}
+}
+
+func TestParseWindow_Error(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"Mon-Fri",
+		"08:00",
+		"8am-6pm",
+		"Foo 08:00-18:00",
+		"08:00-18:00 Nowhere/Nothing",
+	} {
+		if _, err := ParseWindow(s, nil); err == nil {
+			t.Errorf("Want error parsing window %q", s)
+		}
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	windows, err := ParseWindows("Mon-Fri 08:00-18:00; Sat,Sun 10:00-12:00 UTC", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(windows), 2; got != want {
+		t.Fatalf("Want %d windows, got %d", want, got)
+	}
+	if got, want := windows[1].String(), "Sat,Sun 10:00-12:00 UTC"; got != want {
+		t.Errorf("Want window %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"docker.io/go-docker"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// endpoint defines a Docker daemon managed by the garbage
+// collector, with optional per-host policy overrides.
+type endpoint struct {
+	Name        string
+	Host        string
+	TLSCertPath string
+	TLSVerify   bool
+	Cache       string
+	MinImageAge time.Duration
+	Dangling    *bool
+	Root        string
+}
+
+// parseEndpoints parses the list of endpoints. Each endpoint
+// is a Docker host followed by optional semicolon-separated
+// settings, for example:
+//
+//	tcp://10.0.0.2:2376;name=dind;tls=/certs/dind;cache=10gb
+func parseEndpoints(hosts []string) ([]endpoint, error) {
+	var endpoints []endpoint
+	names := map[string]bool{}
+	for _, host := range hosts {
+		e, err := parseEndpoint(host)
+		if err != nil {
+			return nil, err
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("duplicate host name %q", e.Name)
+		}
+		names[e.Name] = true
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+func parseEndpoint(s string) (endpoint, error) {
+	parts := strings.Split(strings.TrimSpace(s), ";")
+	e := endpoint{Host: parts[0]}
+	u, err := url.Parse(e.Host)
+	if err != nil || u.Scheme == "" {
+		return e, fmt.Errorf("invalid host %q", e.Host)
+	}
+	e.Name = u.Host + u.Path
+
+	// tls certificates are verified unless disabled by the
+	// tls-verify setting, in either order.
+	var verify *bool
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return e, fmt.Errorf("host %s: invalid setting %q", e.Host, part)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "name":
+			e.Name = value
+		case "tls":
+			e.TLSCertPath = value
+			e.TLSVerify = true
+		case "tls-verify":
+			var v bool
+			v, err = strconv.ParseBool(value)
+			verify = &v
+		case "cache":
+			e.Cache = value
+		case "min-image-age":
+			e.MinImageAge, err = time.ParseDuration(value)
+		case "root":
+			e.Root = value
+		case "dangling":
+			var dangling bool
+			dangling, err = strconv.ParseBool(value)
+			e.Dangling = &dangling
+		default:
+			err = fmt.Errorf("unknown setting %q", key)
+		}
+		if err != nil {
+			return e, fmt.Errorf("host %s: %s", e.Host, err)
+		}
+	}
+	if verify != nil {
+		e.TLSVerify = *verify
+	}
+	return e, nil
+}
+
+// newClient returns a Docker client for the endpoint. The
+// client is configured from the environment if the endpoint
+// has no host.
+func newClient(e endpoint) (docker.APIClient, error) {
+	if e.Host == "" {
+		return docker.NewEnvClient()
+	}
+	var client *http.Client
+	if e.TLSCertPath != "" {
+		options := tlsconfig.Options{
+			CAFile:             filepath.Join(e.TLSCertPath, "ca.pem"),
+			CertFile:           filepath.Join(e.TLSCertPath, "cert.pem"),
+			KeyFile:            filepath.Join(e.TLSCertPath, "key.pem"),
+			InsecureSkipVerify: !e.TLSVerify,
+		}
+		config, err := tlsconfig.Client(options)
+		if err != nil {
+			return nil, err
+		}
+		client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: config,
+			},
+		}
+	}
+	return docker.NewClient(e.Host, os.Getenv("DOCKER_API_VERSION"), client, nil)
+}
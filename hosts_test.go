@@ -0,0 +1,85 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	endpoints, err := parseEndpoints([]string{
+		"unix:///var/run/docker.sock",
+		"tcp://10.0.0.2:2376;name=dind;tls=/certs/dind;cache=10gb;min-image-age=30m;dangling=true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(endpoints), 2; got != want {
+		t.Fatalf("Want %d endpoints, got %d", want, got)
+	}
+
+	local := endpoints[0]
+	if got, want := local.Name, "/var/run/docker.sock"; got != want {
+		t.Errorf("Want endpoint name %q, got %q", want, got)
+	}
+	if local.TLSCertPath != "" || local.Dangling != nil {
+		t.Errorf("Want endpoint without overrides")
+	}
+
+	dind := endpoints[1]
+	if got, want := dind.Name, "dind"; got != want {
+		t.Errorf("Want endpoint name %q, got %q", want, got)
+	}
+	if got, want := dind.Host, "tcp://10.0.0.2:2376"; got != want {
+		t.Errorf("Want endpoint host %q, got %q", want, got)
+	}
+	if got, want := dind.TLSCertPath, "/certs/dind"; got != want || !dind.TLSVerify {
+		t.Errorf("Want verified tls certificates in %q, got %q", want, got)
+	}
+	if got, want := dind.Cache, "10gb"; got != want {
+		t.Errorf("Want cache %q, got %q", want, got)
+	}
+	if got, want := dind.MinImageAge, 30*time.Minute; got != want {
+		t.Errorf("Want min image age %s, got %s", want, got)
+	}
+	if dind.Dangling == nil || !*dind.Dangling {
+		t.Errorf("Want dangling image collection enabled")
+	}
+}
+
+// this test verifies that the tls-verify setting applies
+// regardless of its order relative to the tls setting.
+func TestParseEndpoints_TLSVerify(t *testing.T) {
+	for _, host := range []string{
+		"tcp://10.0.0.2:2376;tls=/certs/dind;tls-verify=false",
+		"tcp://10.0.0.2:2376;tls-verify=false;tls=/certs/dind",
+	} {
+		e, err := parseEndpoint(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.TLSVerify {
+			t.Errorf("Want tls verification disabled for host %q", host)
+		}
+		if got, want := e.TLSCertPath, "/certs/dind"; got != want {
+			t.Errorf("Want tls certificates in %q, got %q", want, got)
+		}
+	}
+}
+
+func TestParseEndpoints_Error(t *testing.T) {
+	for _, hosts := range [][]string{
+		{"10.0.0.2:2376"},
+		{"tcp://10.0.0.2:2376;cache"},
+		{"tcp://10.0.0.2:2376;foo=bar"},
+		{"tcp://10.0.0.2:2376;min-image-age=1d"},
+		{"tcp://10.0.0.2:2376", "tcp://10.0.0.2:2376"},
+	} {
+		if _, err := parseEndpoints(hosts); err == nil {
+			t.Errorf("Want error parsing hosts %v", hosts)
+		}
+	}
+}
@@ -7,14 +7,23 @@ package main
 import (
 	"context"
 	"docker.io/go-docker/api/types"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/drone/drone-gc/gc"
+	"github.com/drone/drone-gc/gc/audit"
 	"github.com/drone/drone-gc/gc/cache"
+	"github.com/drone/drone-gc/gc/cron"
+	"github.com/drone/drone-gc/gc/lock"
+	"github.com/drone/drone-gc/gc/registry"
+	"github.com/drone/drone-gc/gc/replay"
 	"github.com/drone/signal"
 
-	"docker.io/go-docker"
 	"github.com/docker/go-units"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/rs/zerolog"
@@ -26,17 +35,89 @@ type config struct {
 	Debug                 bool          `envconfig:"GC_DEBUG"`
 	Color                 bool          `envconfig:"GC_DEBUG_COLOR"`
 	Pretty                bool          `envconfig:"GC_DEBUG_PRETTY"`
+	Hosts                 []string      `envconfig:"GC_HOSTS"`
 	Images                []string      `envconfig:"GC_IGNORE_IMAGES"`
 	Containers            []string      `envconfig:"GC_IGNORE_CONTAINERS"`
+	Networks              []string      `envconfig:"GC_IGNORE_NETWORKS"`
+	Volumes               []string      `envconfig:"GC_IGNORE_VOLUMES"`
+	ContainerTTL          time.Duration `envconfig:"GC_CONTAINER_TTL"`
+	NetworkTTL            time.Duration `envconfig:"GC_NETWORK_TTL"`
+	VolumeTTL             time.Duration `envconfig:"GC_VOLUME_TTL"`
+	MalformedExpiry       string        `envconfig:"GC_MALFORMED_EXPIRY" default:"warn"`
+	RegistryAliases       []string      `envconfig:"GC_REGISTRY_ALIASES"`
+	Unpullable            string        `envconfig:"GC_UNPULLABLE_IMAGES" default:"ignore"`
+	RegistryCheck         []string      `envconfig:"GC_REGISTRY_CHECK"`
+	PullCostWeight        float64       `envconfig:"GC_PULL_COST_WEIGHT"`
+	PullOverhead          time.Duration `envconfig:"GC_PULL_OVERHEAD" default:"5s"`
+	PullThroughput        string        `envconfig:"GC_PULL_THROUGHPUT" default:"50mb"`
+	RegistryThroughput    []string      `envconfig:"GC_REGISTRY_THROUGHPUT"`
 	Interval              time.Duration `envconfig:"GC_INTERVAL" default:"5m"`
+	Schedule              string        `envconfig:"GC_SCHEDULE"`
+	Timezone              string        `envconfig:"GC_TIMEZONE" default:"Local"`
+	Jitter                time.Duration `envconfig:"GC_JITTER"`
+	RunOnStartup          bool          `envconfig:"GC_RUN_ON_STARTUP"`
+	Blackout              string        `envconfig:"GC_BLACKOUT"`
+	Backoff               time.Duration `envconfig:"GC_BACKOFF" default:"1m"`
+	MaxBackoff            time.Duration `envconfig:"GC_MAX_BACKOFF" default:"1h"`
+	MaxFailures           int           `envconfig:"GC_MAX_FAILURES"`
+	TriggerOnPull         bool          `envconfig:"GC_TRIGGER_ON_PULL"`
+	TriggerDebounce       time.Duration `envconfig:"GC_TRIGGER_DEBOUNCE" default:"30s"`
+	RegretWindow          time.Duration `envconfig:"GC_REGRET_WINDOW" default:"1h"`
+	EventMaxGap           time.Duration `envconfig:"GC_EVENT_MAX_GAP" default:"5m"`
+	UsageCacheSize        int           `envconfig:"GC_USAGE_CACHE_SIZE" default:"1000"`
+	DockerRoot            string        `envconfig:"GC_DOCKER_ROOT"`
+	AutoTune              bool          `envconfig:"GC_AUTO_TUNE"`
+	AutoTuneMin           string        `envconfig:"GC_AUTO_TUNE_MIN"`
+	AutoTuneMax           string        `envconfig:"GC_AUTO_TUNE_MAX"`
+	Profiles              string        `envconfig:"GC_PROFILES"`
+	DeferLabels           []string      `envconfig:"GC_DEFER_LABELS"`
+	DeferNames            []string      `envconfig:"GC_DEFER_NAMES"`
+	MaxDeferral           time.Duration `envconfig:"GC_MAX_DEFERRAL" default:"1h"`
 	MinImageAge           time.Duration `envconfig:"GC_MIN_IMAGE_AGE" default:"1h"`
 	Cache                 string        `envconfig:"GC_CACHE" default:"5gb"`
 	CollectDanglingImages bool          `envconfig:"GC_COLLECT_DANGLING_IMAGES"`
 	PruneChildren         bool          `envconfig:"GC_PRUNE_CHILDREN"`
 	ForceRemoval          bool          `envconfig:"GC_FORCE_REMOVAL"`
+	Concurrency           int           `envconfig:"GC_CONCURRENCY" default:"1"`
+	RateLimit             float64       `envconfig:"GC_RATE_LIMIT"`
+	BudgetImages          int           `envconfig:"GC_BUDGET_IMAGES"`
+	BudgetBytes           string        `envconfig:"GC_BUDGET_BYTES"`
+	BudgetContainers      int           `envconfig:"GC_BUDGET_CONTAINERS"`
+	BreakerPercent        float64       `envconfig:"GC_BREAKER_PERCENT"`
+	BreakerOverride       bool          `envconfig:"GC_BREAKER_OVERRIDE"`
+	LockDir               string        `envconfig:"GC_LOCK_DIR"`
+	LockTTL               time.Duration `envconfig:"GC_LOCK_TTL" default:"1m"`
+	AdminAddr             string        `envconfig:"GC_ADMIN_ADDR"`
+	AdminToken            string        `envconfig:"GC_ADMIN_TOKEN"`
+	AuditLog              string        `envconfig:"GC_AUDIT_LOG"`
+	AuditLogMaxSize       string        `envconfig:"GC_AUDIT_LOG_MAX_SIZE" default:"100mb"`
+	AuditLogMaxBackups    int           `envconfig:"GC_AUDIT_LOG_MAX_BACKUPS" default:"5"`
+	Record                string        `envconfig:"GC_RECORD"`
+	RecordInterval        time.Duration `envconfig:"GC_RECORD_INTERVAL" default:"15m"`
+}
+
+// instance is the garbage collector for a single Docker host.
+type instance struct {
+	name      string
+	ctx       context.Context
+	backend   gc.Backend
+	collector gc.Collector
+	scheduler *gc.Scheduler
+	breaker   *gc.Breaker
+	tuner     *gc.Tuner
+	tracker   *cache.Tracker
+	lock      *lock.Lock
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := simulate(os.Args[2:]); err != nil {
+			log.Fatal().Err(err).
+				Msg("Cannot simulate the recording")
+		}
+		return
+	}
+
 	cfg := new(config)
 	err := envconfig.Process("", cfg)
 	if err != nil {
@@ -44,49 +125,462 @@ func main() {
 			Msg("Cannot load configuration variables")
 	}
 
-	client, err := docker.NewEnvClient()
+	endpoints, err := parseEndpoints(cfg.Hosts)
 	if err != nil {
 		log.Fatal().Err(err).
-			Msg("Cannot create Docker client")
+			Msg("Cannot parse Docker hosts")
 	}
-
-	size, err := units.FromHumanSize(cfg.Cache)
-	if err != nil {
-		log.Fatal().Err(err).
-			Msg("Cannot parse cache size")
+	if len(endpoints) == 0 {
+		// default to the Docker host configured
+		// in the environment.
+		endpoints = []endpoint{{Root: cfg.DockerRoot}}
 	}
 
 	initLogger(cfg)
 	ctx := log.Logger.WithContext(context.Background())
 	ctx = signal.WithContext(ctx)
 
-	collector := gc.New(
-		cache.Wrap(ctx, client),
+	auditor, err := initAuditLog(cfg)
+	if err != nil {
+		log.Fatal().Err(err).
+			Msg("Cannot open audit log")
+	}
+
+	var instances []*instance
+	for _, e := range endpoints {
+		inst, err := initInstance(ctx, cfg, e, auditor)
+		if err != nil {
+			log.Fatal().Err(err).
+				Str("host", e.Name).
+				Msg("Cannot configure the garbage collector")
+		}
+		instances = append(instances, inst)
+	}
+
+	if cfg.Once {
+		for _, inst := range instances {
+			inst.collect()
+		}
+		return
+	}
+
+	if cfg.Record != "" {
+		f, err := os.OpenFile(cfg.Record, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal().Err(err).
+				Msg("Cannot open recording")
+		}
+		defer f.Close()
+		recorder := replay.NewRecorder(f)
+		for _, inst := range instances {
+			go recorder.Record(inst.ctx, inst.name, inst.backend, cfg.RecordInterval)
+		}
+	}
+
+	if cfg.AdminAddr != "" {
+		serve(ctx, cfg.AdminAddr, &server{
+			instances: instances,
+			token:     cfg.AdminToken,
+		})
+	}
+
+	log.Info().
+		Int("hosts", len(instances)).
+		Strs("ignore-containers", cfg.Containers).
+		Strs("ignore-images", cfg.Images).
+		Str("cache", cfg.Cache).
+		Str("interval", units.HumanDuration(cfg.Interval)).
+		Str("schedule", cfg.Schedule).
+		Str("blackout", cfg.Blackout).
+		Str("minimal image age", units.HumanDuration(cfg.MinImageAge)).
+		Msg("starting the garbage collector")
+
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func(inst *instance) {
+			defer wg.Done()
+			err := inst.run()
+			if err != nil && err != context.Canceled {
+				log.Fatal().Err(err).
+					Str("host", inst.name).
+					Int("failures", inst.scheduler.Failures()).
+					Msg("Garbage collector stopped")
+			}
+		}(inst)
+	}
+	wg.Wait()
+}
+
+// initInstance configures the garbage collector and scheduler
+// for the Docker host.
+func initInstance(ctx context.Context, cfg *config, e endpoint, auditor audit.Logger) (*instance, error) {
+	inst := &instance{name: e.Name}
+	inst.ctx = ctx
+	if e.Name != "" {
+		inst.ctx = log.Ctx(ctx).With().
+			Str("host", e.Name).
+			Logger().
+			WithContext(ctx)
+	}
+
+	client, err := newClient(e)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSize := cfg.Cache
+	if e.Cache != "" {
+		cacheSize = e.Cache
+	}
+	size, err := units.FromHumanSize(cacheSize)
+	if err != nil {
+		return nil, err
+	}
+	minImageAge := cfg.MinImageAge
+	if e.MinImageAge != 0 {
+		minImageAge = e.MinImageAge
+	}
+	dangling := cfg.CollectDanglingImages
+	if e.Dangling != nil {
+		dangling = *e.Dangling
+	}
+
+	profiles, err := initProfiles(cfg, gc.Profile{
+		Threshold:             size,
+		MinImageAge:           minImageAge,
+		CollectDanglingImages: dangling,
+		ContainerTTL:          cfg.ContainerTTL,
+		NetworkTTL:            cfg.NetworkTTL,
+		VolumeTTL:             cfg.VolumeTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	budget, err := initBudget(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	registries, err := parseRegistryAliases(cfg.RegistryAliases)
+	if err != nil {
+		return nil, err
+	}
+
+	registry, err := initRegistry(cfg, registries)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.MalformedExpiry {
+	case gc.MalformedWarn, gc.MalformedIgnore, gc.MalformedExpire:
+	default:
+		return nil, fmt.Errorf("invalid malformed expiry policy %q", cfg.MalformedExpiry)
+	}
+
+	opts := []gc.Option{
+		gc.WithRegistryAliases(registries),
+		gc.WithUnpullableImages(cfg.Unpullable),
+		gc.WithHost(e.Name),
 		gc.WithImageWhitelist(gc.ReservedImages),
 		gc.WithImageWhitelist(cfg.Images),
 		gc.WithThreshold(size),
 		gc.WithWhitelist(gc.ReservedNames),
-		gc.WithMinImageAge(cfg.MinImageAge),
+		gc.WithMinImageAge(minImageAge),
 		gc.WithWhitelist(cfg.Containers),
-		gc.WithDanglingImagesCollection(cfg.CollectDanglingImages),
+		gc.WithNetworkWhitelist(cfg.Networks),
+		gc.WithVolumeWhitelist(cfg.Volumes),
+		gc.WithContainerTTL(cfg.ContainerTTL),
+		gc.WithNetworkTTL(cfg.NetworkTTL),
+		gc.WithVolumeTTL(cfg.VolumeTTL),
+		gc.WithMalformedExpiry(cfg.MalformedExpiry),
+		gc.WithDanglingImagesCollection(dangling),
 		gc.WithImageRemoveOptions(types.ImageRemoveOptions{
 			PruneChildren: cfg.PruneChildren,
 			Force:         cfg.ForceRemoval,
 		}),
+		gc.WithConcurrency(cfg.Concurrency),
+		gc.WithRateLimit(cfg.RateLimit),
+		gc.WithAuditLog(auditor),
+		gc.WithProfiles(profiles...),
+		gc.WithBudget(budget),
+	}
+	if cfg.BreakerPercent > 0 {
+		inst.breaker = gc.NewBreaker(cfg.BreakerPercent)
+		if cfg.BreakerOverride {
+			inst.breaker.Override()
+		}
+		opts = append(opts, gc.WithCircuitBreaker(inst.breaker))
+	}
+	if registry != nil {
+		opts = append(opts, gc.WithRegistryCheck(registry))
+	}
+	if cfg.PullCostWeight != 0 {
+		cost, err := initPullCost(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, gc.WithPullCost(cost, cfg.PullCostWeight))
+	}
+	inst.tuner, err = initTuner(cfg, e, size)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, gc.WithTuner(inst.tuner))
+	if len(cfg.DeferLabels) != 0 || len(cfg.DeferNames) != 0 {
+		opts = append(opts, gc.WithBuildDetection(cfg.DeferLabels, cfg.DeferNames, cfg.MaxDeferral))
+	}
+
+	// the trigger channel is signalled by the cache listener
+	// when image pulls push the cache above the threshold.
+	var trigger chan struct{}
+	if cfg.TriggerOnPull {
+		trigger = make(chan struct{}, 1)
+	}
+
+	inst.backend = gc.NewDockerBackend(client)
+	// the collector is created after the tracker, but before
+	// the tracker listens for pulls.
+	threshold := func() int64 { return gc.Threshold(inst.collector) }
+	inst.tracker = cache.NewTracker(inst.backend,
+		cache.WithTrigger(threshold, trigger),
+		cache.WithRegretWindow(cfg.RegretWindow),
+		cache.WithMaxEventGap(cfg.EventMaxGap),
+		cache.WithSize(cfg.UsageCacheSize),
+		cache.WithRegistryAliases(registries),
 	)
-	if cfg.Once {
-		collector.Collect(ctx)
-	} else {
-		log.Info().
-			Strs("ignore-containers", cfg.Containers).
-			Strs("ignore-images", cfg.Images).
-			Str("cache", cfg.Cache).
-			Str("interval", units.HumanDuration(cfg.Interval)).
-			Str("minimal image age", units.HumanDuration(cfg.MinImageAge)).
-			Msg("starting the garbage collector")
-
-		gc.Schedule(ctx, collector, cfg.Interval)
+	inst.collector = gc.New(inst.tracker.Backend(), opts...)
+	go inst.tracker.Listen(inst.ctx)
+	inst.scheduler, err = initScheduler(cfg, inst.collector, trigger)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.LockDir != "" {
+		inst.lock = lock.New(filepath.Join(cfg.LockDir, lockName(e.Name)), cfg.LockTTL)
+	}
+	return inst, nil
+}
+
+// collect runs a single collection once the host lock is
+// acquired.
+func (inst *instance) collect() {
+	if inst.lock != nil {
+		if err := inst.lock.Lock(inst.ctx); err != nil {
+			return
+		}
+		defer inst.lock.Unlock()
+	}
+	inst.collector.Collect(inst.ctx)
+}
+
+// run runs the scheduler while the host lock is held. If the
+// lock is lost the scheduler is stopped and the instance
+// stands by until the lock is acquired again.
+func (inst *instance) run() error {
+	if inst.lock == nil {
+		return inst.scheduler.Run(inst.ctx)
+	}
+	for {
+		if err := inst.lock.Lock(inst.ctx); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithCancel(inst.ctx)
+		go func() {
+			err := inst.lock.Hold(ctx)
+			if err == lock.ErrLost {
+				log.Ctx(ctx).Warn().Err(err).
+					Msg("lock held by another instance, stopping")
+			}
+			cancel()
+		}()
+		err := inst.scheduler.Run(ctx)
+		cancel()
+		inst.lock.Unlock()
+		if inst.ctx.Err() != nil || err != context.Canceled {
+			return err
+		}
+	}
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// lockName returns the lock file name for the Docker host.
+func lockName(host string) string {
+	if host == "" {
+		host = "default"
+	}
+	return unsafeChars.ReplaceAllString(host, "_") + ".lock"
+}
+
+func initScheduler(cfg *config, collector gc.Collector, trigger <-chan struct{}) (*gc.Scheduler, error) {
+	// a one-off collection does not require a schedule.
+	if cfg.Schedule == "" && cfg.Interval <= 0 && !cfg.Once {
+		return nil, gc.ErrInvalidInterval
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	blackouts, err := gc.ParseWindows(cfg.Blackout, loc)
+	if err != nil {
+		return nil, err
+	}
+	opts := []gc.ScheduleOption{
+		gc.WithInterval(cfg.Interval),
+		gc.WithLocation(loc),
+		gc.WithJitter(cfg.Jitter),
+		gc.WithRunOnStartup(cfg.RunOnStartup),
+		gc.WithBlackout(blackouts...),
+		gc.WithBackoff(cfg.Backoff, cfg.MaxBackoff),
+		gc.WithMaxFailures(cfg.MaxFailures),
+		gc.WithTrigger(trigger),
+		gc.WithDebounce(cfg.TriggerDebounce),
+	}
+	if cfg.Schedule != "" {
+		expr, err := cron.Parse(cfg.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, gc.WithCron(expr))
+	}
+	return gc.NewScheduler(collector, opts...), nil
+}
+
+func initProfiles(cfg *config, base gc.Profile) ([]gc.Profile, error) {
+	if cfg.Profiles == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return gc.ParseProfiles([]byte(cfg.Profiles), base, loc)
+}
+
+// initTuner returns the threshold tuner. In auto mode, the
+// bounds default to half and twice the configured threshold,
+// and are scaled to the profile thresholds.
+func initTuner(cfg *config, e endpoint, threshold int64) (*gc.Tuner, error) {
+	var min, max int64
+	if cfg.AutoTune {
+		min, max = threshold/2, threshold*2
+	}
+	if cfg.AutoTuneMin != "" {
+		size, err := units.FromHumanSize(cfg.AutoTuneMin)
+		if err != nil {
+			return nil, err
+		}
+		min = size
+	}
+	if cfg.AutoTuneMax != "" {
+		size, err := units.FromHumanSize(cfg.AutoTuneMax)
+		if err != nil {
+			return nil, err
+		}
+		max = size
+	}
+	return gc.NewTuner(e.Root, threshold, min, max, cfg.AutoTune), nil
+}
+
+// parseRegistryAliases parses registry aliases in the
+// alias=registry format, for example mirror.internal=docker.io.
+func parseRegistryAliases(aliases []string) (map[string]string, error) {
+	registries := map[string]string{}
+	for _, v := range aliases {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid registry alias %q", v)
+		}
+		registries[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return registries, nil
+}
+
+// initRegistry returns the registry client used to check
+// that image manifests exist, or nil if no registry is
+// configured. Registries are configured by host, with an
+// optional API address.
+func initRegistry(cfg *config, aliases map[string]string) (*registry.Client, error) {
+	switch cfg.Unpullable {
+	case gc.UnpullableIgnore, gc.UnpullableProtect, gc.UnpullableLast:
+	default:
+		return nil, fmt.Errorf("invalid unpullable images policy %q", cfg.Unpullable)
+	}
+	if len(cfg.RegistryCheck) == 0 {
+		return nil, nil
+	}
+	// the registry check only applies to the protect and
+	// last policies.
+	if cfg.Unpullable == gc.UnpullableIgnore {
+		return nil, fmt.Errorf("registry check requires the %s or %s unpullable images policy", gc.UnpullableProtect, gc.UnpullableLast)
+	}
+	endpoints := map[string]string{}
+	for _, v := range cfg.RegistryCheck {
+		kv := strings.SplitN(v, "=", 2)
+		host := strings.TrimSpace(kv[0])
+		switch {
+		case len(kv) == 2:
+			endpoints[host] = strings.TrimSpace(kv[1])
+		case host == "docker.io":
+			endpoints[host] = "https://registry-1.docker.io"
+		default:
+			endpoints[host] = "https://" + host
+		}
+	}
+	return registry.New(endpoints, registry.WithAliases(aliases))
+}
+
+// initPullCost returns the pull cost model. Throughputs are
+// sizes transferred per second.
+func initPullCost(cfg *config) (gc.PullCost, error) {
+	cost := gc.PullCost{
+		Overhead:   cfg.PullOverhead,
+		Registries: map[string]int64{},
+	}
+	var err error
+	cost.Throughput, err = units.FromHumanSize(cfg.PullThroughput)
+	if err != nil {
+		return cost, err
+	}
+	for _, v := range cfg.RegistryThroughput {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			return cost, fmt.Errorf("invalid registry throughput %q", v)
+		}
+		size, err := units.FromHumanSize(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return cost, err
+		}
+		cost.Registries[strings.TrimSpace(kv[0])] = size
+	}
+	return cost, nil
+}
+
+func initBudget(cfg *config) (gc.Budget, error) {
+	budget := gc.Budget{
+		Images:     cfg.BudgetImages,
+		Containers: cfg.BudgetContainers,
+	}
+	if cfg.BudgetBytes != "" {
+		size, err := units.FromHumanSize(cfg.BudgetBytes)
+		if err != nil {
+			return budget, err
+		}
+		budget.Bytes = size
+	}
+	return budget, nil
+}
+
+func initAuditLog(cfg *config) (audit.Logger, error) {
+	if cfg.AuditLog == "" {
+		return audit.Discard, nil
+	}
+	size, err := units.FromHumanSize(cfg.AuditLogMaxSize)
+	if err != nil {
+		return nil, err
 	}
+	return audit.Open(cfg.AuditLog, size, cfg.AuditLogMaxBackups)
 }
 
 func initLogger(cfg *config) {
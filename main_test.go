@@ -0,0 +1,68 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/drone/drone-gc/gc"
+)
+
+func TestParseRegistryAliases(t *testing.T) {
+	got, err := parseRegistryAliases([]string{"mirror.internal=docker.io", " registry.local:5000/quay = quay.io "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"mirror.internal":          "docker.io",
+		"registry.local:5000/quay": "quay.io",
+	}
+	if len(got) != len(want) {
+		t.Errorf("Want %d registry aliases, got %d", len(want), len(got))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Want alias %s mapped to %s, got %q", k, v, got[k])
+		}
+	}
+	if _, err := parseRegistryAliases([]string{"mirror.internal"}); err == nil {
+		t.Errorf("Want error parsing alias without registry")
+	}
+}
+
+func TestInitRegistry(t *testing.T) {
+	cfg := &config{Unpullable: "ignore", RegistryCheck: []string{"registry.local:5000"}}
+	if _, err := initRegistry(cfg, nil); err == nil {
+		t.Errorf("Want error with registry check and ignore policy")
+	}
+	cfg.Unpullable = "protect"
+	client, err := initRegistry(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client == nil {
+		t.Errorf("Want registry client")
+	}
+	cfg.RegistryCheck = nil
+	if client, _ := initRegistry(cfg, nil); client != nil {
+		t.Errorf("Want no registry client without registry check")
+	}
+}
+
+func TestInitScheduler_Interval(t *testing.T) {
+	cfg := &config{}
+	if _, err := initScheduler(cfg, nil, nil); err != gc.ErrInvalidInterval {
+		t.Errorf("Want invalid interval error without schedule, got %v", err)
+	}
+	cfg.Schedule = "0 * * * *"
+	if _, err := initScheduler(cfg, nil, nil); err != nil {
+		t.Errorf("Want zero interval allowed with schedule, got %v", err)
+	}
+	cfg.Schedule = ""
+	cfg.Once = true
+	if _, err := initScheduler(cfg, nil, nil); err != nil {
+		t.Errorf("Want zero interval allowed in once mode, got %v", err)
+	}
+}
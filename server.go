@@ -0,0 +1,132 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/drone/drone-gc/gc/cache"
+
+	"github.com/rs/zerolog/log"
+)
+
+// server exposes the garbage collector status and the
+// circuit breaker override over http.
+type server struct {
+	instances []*instance
+	token     string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/status" && r.Method == http.MethodGet:
+		s.handleStatus(w, r)
+	case r.URL.Path == "/breaker/override" && r.Method == http.MethodPost:
+		s.handleOverride(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type status struct {
+	Host             string        `json:"host,omitempty"`
+	Failures         int           `json:"failures"`
+	BreakerTripped   bool          `json:"breaker_tripped"`
+	RecommendedCache int64         `json:"recommended_cache,omitempty"`
+	Events           *cache.Health `json:"events,omitempty"`
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	out := []status{}
+	for _, inst := range s.instances {
+		v := status{
+			Host:     inst.name,
+			Failures: inst.scheduler.Failures(),
+		}
+		if inst.breaker != nil {
+			v.BreakerTripped = inst.breaker.Tripped()
+		}
+		if inst.tuner != nil {
+			v.RecommendedCache = inst.tuner.Recommended()
+		}
+		if inst.tracker != nil {
+			health := inst.tracker.Health()
+			v.Events = &health
+		}
+		out = append(out, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleOverride overrides the circuit breaker of the host
+// in the query string, or of every host if omitted.
+func (s *server) handleOverride(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		log.Warn().
+			Str("remote", r.RemoteAddr).
+			Msg("unauthorized circuit breaker override")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	host := r.URL.Query().Get("host")
+	found := false
+	for _, inst := range s.instances {
+		if inst.breaker == nil || (host != "" && host != inst.name) {
+			continue
+		}
+		log.Warn().
+			Str("host", inst.name).
+			Str("remote", r.RemoteAddr).
+			Msg("circuit breaker override requested")
+		inst.breaker.Override()
+		found = true
+	}
+	if !found {
+		http.Error(w, "circuit breaker not configured", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorized returns true if the request may change the
+// collector state. If a token is configured, the request must
+// present it as a bearer token. Otherwise the request must
+// originate from the local host.
+func (s *server) authorized(r *http.Request) bool {
+	if s.token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serve starts the http server and stops it when the
+// context is cancelled.
+func serve(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).
+				Str("addr", addr).
+				Msg("Cannot start the admin server")
+		}
+	}()
+}
@@ -0,0 +1,46 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drone/drone-gc/gc"
+)
+
+func TestServer_Override(t *testing.T) {
+	tests := []struct {
+		token  string
+		header string
+		remote string
+		want   int
+	}{
+		{"", "", "127.0.0.1:41234", http.StatusNoContent},
+		{"", "", "[::1]:41234", http.StatusNoContent},
+		{"", "", "10.0.0.2:41234", http.StatusUnauthorized},
+		{"s3cr3t", "", "127.0.0.1:41234", http.StatusUnauthorized},
+		{"s3cr3t", "Bearer wrong", "10.0.0.2:41234", http.StatusUnauthorized},
+		{"s3cr3t", "Bearer s3cr3t", "10.0.0.2:41234", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		breaker := gc.NewBreaker(50)
+		s := &server{
+			instances: []*instance{{name: "agent1", breaker: breaker}},
+			token:     test.token,
+		}
+		r := httptest.NewRequest("POST", "/breaker/override", nil)
+		r.RemoteAddr = test.remote
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if got, want := w.Code, test.want; got != want {
+			t.Errorf("Want status %d from %s with token %q, got %d", want, test.remote, test.header, got)
+		}
+	}
+}
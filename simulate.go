@@ -0,0 +1,66 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Blue Oak Model License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/drone/drone-gc/gc/replay"
+
+	"github.com/docker/go-units"
+)
+
+// simulate replays a recording against the eviction policy
+// and threshold, and writes the report to stdout.
+func simulate(args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	policy := flags.String("policy", replay.PolicyLRU, "eviction policy (lru or fifo)")
+	cacheSize := flags.String("cache", "5gb", "image cache size")
+	interval := flags.Duration("interval", 5*time.Minute, "collection interval")
+	minImageAge := flags.Duration("min-image-age", time.Hour, "minimum image age before removal")
+	host := flags.String("host", "", "recorded host to replay")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: drone-gc simulate [flags] <recording>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	size, err := units.FromHumanSize(*cacheSize)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := replay.Read(f)
+	if err != nil {
+		return err
+	}
+
+	report, err := replay.Simulate(context.Background(), entries, replay.Config{
+		Policy:      *policy,
+		Threshold:   size,
+		MinImageAge: *minImageAge,
+		Interval:    *interval,
+		Host:        *host,
+	})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}